@@ -0,0 +1,195 @@
+package measured
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// BucketFunc determines the bucket to which a conn's traffic is accounted.
+type BucketFunc func(c Conn) string
+
+// ByIP buckets traffic by remote IP address.
+func ByIP(c Conn) string {
+	ip := remoteIP(c)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// ByID buckets traffic by conn ID.
+func ByID(c Conn) string {
+	return ID(c)
+}
+
+// BySubnet buckets traffic by the remote address's subnet, using the given
+// prefix lengths for IPv4 and IPv6 addresses (e.g. 24 and 64).
+func BySubnet(ipv4Bits int, ipv6Bits int) BucketFunc {
+	ipv4Mask := net.CIDRMask(ipv4Bits, 32)
+	ipv6Mask := net.CIDRMask(ipv6Bits, 128)
+	return func(c Conn) string {
+		ip := remoteIP(c)
+		if ip == nil {
+			return ""
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return (&net.IPNet{IP: ip4.Mask(ipv4Mask), Mask: ipv4Mask}).String()
+		}
+		return (&net.IPNet{IP: ip.Mask(ipv6Mask), Mask: ipv6Mask}).String()
+	}
+}
+
+// ByASN buckets traffic by the autonomous system of the remote address, as
+// determined by the given resolver (e.g. backed by a MaxMind ASN database).
+// Conns whose address can't be resolved are bucketed under "".
+func ByASN(resolve func(ip net.IP) (string, error)) BucketFunc {
+	return func(c Conn) string {
+		ip := remoteIP(c)
+		if ip == nil {
+			return ""
+		}
+		asn, err := resolve(ip)
+		if err != nil {
+			return ""
+		}
+		return asn
+	}
+}
+
+// Totals are the bytes sent and received by a bucket.
+type Totals struct {
+	Sent int
+	Recv int
+}
+
+// Aggregator accumulates traffic from many conns into buckets and periodically
+// reports the per-bucket totals. Conns are added to an Aggregator by wrapping
+// them WithAggregator.
+type Aggregator struct {
+	bucket   BucketFunc
+	report   func(map[string]*Totals)
+	totals   map[string]*Totals
+	mx       sync.Mutex
+	stopCh   chan interface{}
+	stopOnce sync.Once
+	stopWg   sync.WaitGroup
+}
+
+// NewAggregator constructs an Aggregator that buckets traffic using the given
+// BucketFunc and calls report with the totals accumulated during each interval.
+// report is not called for intervals without traffic. A zero or negative
+// interval is replaced with DefaultReportInterval.
+func NewAggregator(bucket BucketFunc, interval time.Duration, report func(map[string]*Totals)) *Aggregator {
+	interval = validReportInterval(interval)
+	a := &Aggregator{
+		bucket: bucket,
+		report: report,
+		totals: make(map[string]*Totals),
+		stopCh: make(chan interface{}),
+	}
+	a.stopWg.Add(1)
+	go a.run(interval)
+	return a
+}
+
+func (a *Aggregator) run(interval time.Duration) {
+	defer a.stopWg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stopCh:
+			a.flush()
+			return
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+func (a *Aggregator) flush() {
+	a.mx.Lock()
+	totals := a.totals
+	a.totals = make(map[string]*Totals, len(totals))
+	a.mx.Unlock()
+	if len(totals) > 0 {
+		safely("aggregator report", func() { a.report(totals) })
+	}
+}
+
+func (a *Aggregator) add(bucket string, sent int, recv int) {
+	if sent == 0 && recv == 0 {
+		return
+	}
+	a.mx.Lock()
+	t := a.totals[bucket]
+	if t == nil {
+		t = &Totals{}
+		a.totals[bucket] = t
+	}
+	t.Sent += sent
+	t.Recv += recv
+	a.mx.Unlock()
+}
+
+// Stop stops the Aggregator after reporting any outstanding totals. It's safe
+// to call Stop more than once.
+func (a *Aggregator) Stop() {
+	a.stopOnce.Do(func() { close(a.stopCh) })
+	a.stopWg.Wait()
+}
+
+// trafficSink is implemented by the types that accumulate traffic from many
+// conns, like Aggregator and HeavyHitters.
+type trafficSink interface {
+	bucketOf(c Conn) string
+	add(bucket string, sent int, recv int)
+}
+
+func (a *Aggregator) bucketOf(c Conn) string {
+	return a.bucket(c)
+}
+
+// aggregation tracks how much of a conn's traffic has been accounted to a
+// trafficSink.
+type aggregation struct {
+	aggregator trafficSink
+	bucket     string
+	bucketed   bool
+	sent       int
+	recv       int
+}
+
+// update adds any traffic since the last update to the aggregator. It is only
+// called from the tracking goroutine.
+func (ag *aggregation) update(c *conn) {
+	if !ag.bucketed {
+		ag.bucket = ag.aggregator.bucketOf(c)
+		ag.bucketed = true
+	}
+	sent, _, _, _ := c.sent.get()
+	recv, _, _, _ := c.recv.get()
+	ag.aggregator.add(ag.bucket, sent-ag.sent, recv-ag.recv)
+	ag.sent, ag.recv = sent, recv
+}
+
+func remoteIP(c Conn) net.IP {
+	remoteAddr := c.RemoteAddr()
+	if remoteAddr == nil {
+		return nil
+	}
+	switch addr := remoteAddr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(remoteAddr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
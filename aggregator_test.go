@@ -0,0 +1,96 @@
+package measured
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregator(t *testing.T) {
+	var mx sync.Mutex
+	totals := make(map[string]Totals)
+	a := NewAggregator(BySubnet(24, 64), 10*time.Millisecond, func(interval map[string]*Totals) {
+		mx.Lock()
+		for bucket, t := range interval {
+			total := totals[bucket]
+			total.Sent += t.Sent
+			total.Recv += t.Recv
+			totals[bucket] = total
+		}
+		mx.Unlock()
+	})
+
+	for _, remoteIP := range []string{"1.2.3.4", "1.2.3.5", "5.6.7.8"} {
+		conn := Wrap(&addrConn{remote: &net.TCPAddr{IP: net.ParseIP(remoteIP), Port: 443}}, 10*time.Millisecond, nil, WithAggregator(a))
+		conn.Write([]byte("12345"))
+		conn.Read(make([]byte, 2))
+		time.Sleep(20 * time.Millisecond)
+		conn.Write([]byte("12345"))
+		conn.Close()
+	}
+	time.Sleep(20 * time.Millisecond)
+	a.Stop()
+
+	mx.Lock()
+	defer mx.Unlock()
+	assert.Equal(t, map[string]Totals{
+		"1.2.3.0/24": {Sent: 20, Recv: 4},
+		"5.6.7.0/24": {Sent: 10, Recv: 2},
+	}, totals)
+}
+
+func TestBucketFuncs(t *testing.T) {
+	conn := Wrap(&addrConn{remote: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}}, time.Second, nil)
+	defer conn.Close()
+	assert.Equal(t, "2001:db8::1", ByIP(conn))
+	assert.Equal(t, "2001:db8::/64", BySubnet(24, 64)(conn))
+	assert.Equal(t, "AS64496", ByASN(func(ip net.IP) (string, error) { return "AS64496", nil })(conn))
+	assert.Equal(t, "", ByASN(func(ip net.IP) (string, error) { return "", errors.New("unknown") })(conn))
+}
+
+// addrConn is a net.Conn that does no I/O and has a configurable remote address.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) Read(b []byte) (int, error)  { return len(b), nil }
+func (c *addrConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c *addrConn) Close() error                { return nil }
+func (c *addrConn) LocalAddr() net.Addr         { return nil }
+func (c *addrConn) RemoteAddr() net.Addr        { return c.remote }
+
+func TestAggregatorReportPanic(t *testing.T) {
+	reports := make(chan bool, 10)
+	a := NewAggregator(ByID, 5*time.Millisecond, func(map[string]*Totals) {
+		reports <- true
+		panic("aggregator report")
+	})
+	defer a.Stop()
+	conn := Wrap(&addrConn{}, 5*time.Millisecond, nil, WithAggregator(a))
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		conn.Write([]byte("12345"))
+		select {
+		case <-reports:
+		case <-time.After(time.Second):
+			t.Fatal("aggregator should keep reporting after a panic")
+		}
+	}
+}
+
+func TestAggregatorInvalidInterval(t *testing.T) {
+	assert.NotPanics(t, func() {
+		NewAggregator(ByID, 0, func(map[string]*Totals) {}).Stop()
+	})
+}
+
+func TestAggregatorStopTwice(t *testing.T) {
+	a := NewAggregator(ByID, time.Minute, func(map[string]*Totals) {})
+	a.Stop()
+	assert.NotPanics(t, a.Stop)
+}
@@ -0,0 +1,75 @@
+package measured
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// runCallback calls fn with c, recovering and logging any panic so that a
+// misbehaving callback can't take down the tracking goroutine or prevent other
+// callbacks from running.
+func runCallback(fn func(Conn), c Conn) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("measured: callback for conn %v panicked: %v\n%s", ID(c), p, debug.Stack())
+		}
+	}()
+	fn(c)
+}
+
+// safely calls fn, recovering and logging any panic, for callbacks that
+// aren't specific to a conn. what describes the callback in the log.
+func safely(what string, fn func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("measured: %v panicked: %v\n%s", what, p, debug.Stack())
+		}
+	}()
+	fn()
+}
+
+// CallbackPool runs onFinish callbacks on a fixed number of worker goroutines,
+// so that slow callbacks don't hold up the tracking goroutines of measured
+// conns. Conns use a CallbackPool by being wrapped WithCallbackPool.
+type CallbackPool struct {
+	tasks  chan func()
+	stopWg sync.WaitGroup
+}
+
+// NewCallbackPool starts a CallbackPool with the given number of workers and
+// room for queueSize pending callbacks. Once the queue is full, finishing conns
+// wait for room rather than dropping callbacks. Fewer than 1 worker is raised
+// to 1 and a negative queueSize is treated as 0.
+func NewCallbackPool(workers int, queueSize int) *CallbackPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p := &CallbackPool{tasks: make(chan func(), queueSize)}
+	p.stopWg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *CallbackPool) work() {
+	defer p.stopWg.Done()
+	for task := range p.tasks {
+		task()
+	}
+}
+
+func (p *CallbackPool) submit(task func()) {
+	p.tasks <- task
+}
+
+// Stop waits for all pending callbacks to run and stops the workers. Conns
+// using the pool must not finish after Stop is called.
+func (p *CallbackPool) Stop() {
+	close(p.tasks)
+	p.stopWg.Wait()
+}
@@ -0,0 +1,110 @@
+package measured
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnFinishPanic(t *testing.T) {
+	finished := make(chan interface{})
+	conn := Wrap(&addrConn{}, time.Millisecond, func(c Conn) {
+		panic("boom")
+	})
+	Wrap(conn, time.Millisecond, func(c Conn) {
+		close(finished)
+	})
+	conn.Close()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("additional callbacks should run after a panic")
+	}
+}
+
+func TestOnFinishExactlyOnce(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		a, b := net.Pipe()
+		var calls int32
+		conn := Wrap(a, time.Millisecond, func(c Conn) {
+			atomic.AddInt32(&calls, 1)
+		})
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			b.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			conn.Read(make([]byte, 10))
+		}()
+		go func() {
+			defer wg.Done()
+			conn.Close()
+			conn.Close()
+		}()
+		wg.Wait()
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	}
+}
+
+func TestCallbackPool(t *testing.T) {
+	p := NewCallbackPool(2, 1)
+	var calls int32
+	release := make(chan interface{})
+	for i := 0; i < 5; i++ {
+		conn := Wrap(&addrConn{}, time.Millisecond, func(c Conn) {
+			<-release
+			atomic.AddInt32(&calls, 1)
+		}, WithCallbackPool(p))
+		conn.Close()
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls), "callbacks should be blocked in pool")
+	close(release)
+	for i := 0; i < 100 && atomic.LoadInt32(&calls) < 5; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+	p.Stop()
+}
+
+func TestCallbackPoolNoWorkers(t *testing.T) {
+	p := NewCallbackPool(0, -1)
+	called := make(chan interface{})
+	conn := Wrap(&addrConn{}, time.Millisecond, func(c Conn) {
+		close(called)
+	}, WithCallbackPool(p))
+	conn.Close()
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("pool should have at least one worker")
+	}
+	p.Stop()
+}
+
+func TestIntervalReportPanic(t *testing.T) {
+	finished := make(chan *Stats, 1)
+	conn := Wrap(&addrConn{}, 5*time.Millisecond, func(c Conn) {
+		finished <- c.Stats()
+	}, WithIntervalReport(5*time.Millisecond, func(Conn, *Stats) {
+		panic("interval report")
+	}))
+	conn.Write([]byte("12345"))
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case stats := <-finished:
+		assert.Equal(t, 5, stats.SentTotal)
+	case <-time.After(time.Second):
+		t.Fatal("conn should finish despite a panicking interval report")
+	}
+}
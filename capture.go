@@ -0,0 +1,123 @@
+package measured
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Capture holds the traffic recorded on a conn after capturing was triggered.
+type Capture struct {
+	// Trigger is the error that triggered the capture, or nil if it was
+	// triggered with TriggerCapture
+	Trigger error
+	// Sent and Recv are the most recent bytes sent and received after the
+	// trigger, up to the size configured WithCapture
+	Sent []byte
+	Recv []byte
+	// SentDropped and RecvDropped count the bytes that were overwritten
+	// because they didn't fit
+	SentDropped int
+	RecvDropped int
+}
+
+// capture records traffic into capped ring buffers once triggered.
+type capture struct {
+	triggered int32
+	size      int
+	fn        func(Conn, *Capture)
+	result    Capture
+	sent      ring
+	recv      ring
+	mx        sync.Mutex
+}
+
+// ring keeps the most recent bytes written to it, up to its capacity.
+type ring struct {
+	buf     []byte
+	next    int
+	full    bool
+	written int
+}
+
+func (r *ring) write(b []byte) {
+	size := len(r.buf)
+	r.written += len(b)
+	if len(b) > size {
+		// Only the tail can survive
+		b = b[len(b)-size:]
+	}
+	for len(b) > 0 {
+		n := copy(r.buf[r.next:], b)
+		b = b[n:]
+		r.next += n
+		if r.next == size {
+			r.next = 0
+			r.full = true
+		}
+	}
+}
+
+// bytes returns the contents of the ring, oldest first, along with the number
+// of bytes that were overwritten.
+func (r *ring) bytes() ([]byte, int) {
+	if !r.full {
+		return append([]byte(nil), r.buf[:r.next]...), 0
+	}
+	out := make([]byte, 0, len(r.buf))
+	out = append(out, r.buf[r.next:]...)
+	out = append(out, r.buf[:r.next]...)
+	return out, r.written - len(out)
+}
+
+func newCapture(size int, fn func(Conn, *Capture)) *capture {
+	return &capture{size: size, fn: fn}
+}
+
+func (cp *capture) trigger(err error) {
+	cp.mx.Lock()
+	if atomic.LoadInt32(&cp.triggered) == 0 {
+		cp.result.Trigger = err
+		// Buffers are only allocated once needed
+		cp.sent.buf = make([]byte, cp.size)
+		cp.recv.buf = make([]byte, cp.size)
+		atomic.StoreInt32(&cp.triggered, 1)
+	}
+	cp.mx.Unlock()
+}
+
+func (cp *capture) record(d Direction, b []byte) {
+	if len(b) == 0 || atomic.LoadInt32(&cp.triggered) == 0 {
+		return
+	}
+	cp.mx.Lock()
+	if d == Sent {
+		cp.sent.write(b)
+	} else {
+		cp.recv.write(b)
+	}
+	cp.mx.Unlock()
+}
+
+// deliverCapture hands the capture to the callback if it was triggered.
+func (c *conn) deliverCapture() {
+	cp := c.capture
+	if cp == nil || atomic.LoadInt32(&cp.triggered) == 0 {
+		return
+	}
+	cp.mx.Lock()
+	result := cp.result
+	result.Sent, result.SentDropped = cp.sent.bytes()
+	result.Recv, result.RecvDropped = cp.recv.bytes()
+	cp.mx.Unlock()
+	runCallback(func(c Conn) { cp.fn(c, &result) }, c)
+}
+
+// TriggerCapture starts capturing traffic on c if it was wrapped WithCapture
+// and isn't capturing yet. It can be used as the callback passed to
+// WithThreshold to capture traffic after a threshold has been breached. c may
+// also be a Conn that wraps the measured conn.
+func TriggerCapture(c Conn) {
+	if mc, ok := findConn(c); ok && mc.capture != nil {
+		mc.capture.trigger(nil)
+	}
+}
@@ -0,0 +1,113 @@
+package measured
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyConn fails its first read with err and otherwise behaves like addrConn.
+type flakyConn struct {
+	addrConn
+	err    error
+	failed bool
+}
+
+func (c *flakyConn) Read(b []byte) (int, error) {
+	if !c.failed {
+		c.failed = true
+		return 0, c.err
+	}
+	return copy(b, "response"), nil
+}
+
+func TestCaptureOnError(t *testing.T) {
+	captured := make(chan *Capture, 1)
+	boom := errors.New("boom")
+	conn := Wrap(&flakyConn{err: boom}, time.Second, nil, WithCapture(10, func(c Conn, cp *Capture) {
+		captured <- cp
+	}))
+	conn.Write([]byte("before"))
+	conn.Read(make([]byte, 10))
+	conn.Write([]byte("0123456789abc"))
+	conn.Write([]byte("de"))
+	conn.Read(make([]byte, 10))
+	conn.Close()
+
+	select {
+	case cp := <-captured:
+		assert.Equal(t, boom, cp.Trigger)
+		assert.Equal(t, "56789abcde", string(cp.Sent), "should keep most recent bytes")
+		assert.Equal(t, 5, cp.SentDropped)
+		assert.Equal(t, "response", string(cp.Recv))
+		assert.Equal(t, 0, cp.RecvDropped)
+	case <-time.After(time.Second):
+		t.Fatal("capture should have been delivered")
+	}
+}
+
+func TestCaptureTrigger(t *testing.T) {
+	captured := make(chan *Capture, 1)
+	conn := Wrap(&addrConn{}, time.Second, nil,
+		WithCapture(100, func(c Conn, cp *Capture) { captured <- cp }),
+		WithThreshold(5, TriggerCapture))
+	conn.Write([]byte("12345"))
+	conn.Write([]byte("678"))
+	conn.Close()
+
+	select {
+	case cp := <-captured:
+		assert.Nil(t, cp.Trigger)
+		assert.Equal(t, "12345678", string(cp.Sent), "should include the write that crossed the threshold")
+	case <-time.After(time.Second):
+		t.Fatal("capture should have been delivered")
+	}
+
+	untriggered := Wrap(&addrConn{}, time.Millisecond, nil, WithCapture(100, func(c Conn, cp *Capture) { captured <- cp }))
+	untriggered.Write([]byte("12345"))
+	untriggered.Close()
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, captured, "capture should only be delivered if triggered")
+}
+
+// decoratedConn is a Conn implemented outside of this package that wraps a
+// measured conn.
+type decoratedConn struct {
+	Conn
+}
+
+func (c *decoratedConn) Wrapped() net.Conn { return c.Conn }
+
+func TestCaptureTriggerDecorated(t *testing.T) {
+	captured := make(chan *Capture, 1)
+	conn := Wrap(&addrConn{}, time.Second, nil, WithCapture(100, func(c Conn, cp *Capture) { captured <- cp }))
+	TriggerCapture(&decoratedConn{conn})
+	conn.Write([]byte("12345"))
+	conn.Close()
+
+	select {
+	case cp := <-captured:
+		assert.Equal(t, "12345", string(cp.Sent))
+	case <-time.After(time.Second):
+		t.Fatal("capture should have been triggered through the wrapper")
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := &ring{buf: make([]byte, 4)}
+	r.write([]byte("ab"))
+	b, dropped := r.bytes()
+	assert.Equal(t, "ab", string(b))
+	assert.Equal(t, 0, dropped)
+	r.write([]byte("cdef"))
+	b, _ = r.bytes()
+	assert.Equal(t, "cdef", string(b))
+	r.write([]byte("g"))
+	b, dropped = r.bytes()
+	assert.True(t, bytes.Equal([]byte("defg"), b))
+	assert.Equal(t, 3, dropped)
+}
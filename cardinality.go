@@ -0,0 +1,71 @@
+package measured
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// OverflowTagValue is the value that a CardinalityGuard without buckets
+// substitutes for tag values beyond its limit.
+const OverflowTagValue = "other"
+
+// CardinalityGuard limits the number of distinct values reported per tag key,
+// protecting metrics backends like InfluxDB or Prometheus from unbounded
+// cardinality (e.g. from raw error strings). It's meant to be shared by many
+// conns, which use it by being wrapped WithCardinalityGuard.
+type CardinalityGuard struct {
+	maxValues int
+	buckets   int
+	seen      map[string]map[string]bool
+	mx        sync.Mutex
+}
+
+// NewCardinalityGuard constructs a CardinalityGuard that passes through the
+// first maxValues distinct values of each tag key. Further values are hashed
+// into one of the given number of buckets ("overflow-0", "overflow-1", ...) or,
+// if buckets is zero, replaced with OverflowTagValue.
+func NewCardinalityGuard(maxValues int, buckets int) *CardinalityGuard {
+	return &CardinalityGuard{
+		maxValues: maxValues,
+		buckets:   buckets,
+		seen:      make(map[string]map[string]bool),
+	}
+}
+
+// Guard returns tags with any values beyond the limit replaced. tags itself is
+// not modified.
+func (g *CardinalityGuard) Guard(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return tags
+	}
+	result := make(map[string]string, len(tags))
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	for k, v := range tags {
+		result[k] = g.guard(k, v)
+	}
+	return result
+}
+
+// guard returns the value to report for k=v. g.mx must be held.
+func (g *CardinalityGuard) guard(k string, v string) string {
+	values := g.seen[k]
+	if values == nil {
+		values = make(map[string]bool)
+		g.seen[k] = values
+	}
+	if values[v] {
+		return v
+	}
+	if len(values) < g.maxValues {
+		values[v] = true
+		return v
+	}
+	if g.buckets <= 0 {
+		return OverflowTagValue
+	}
+	h := fnv.New32a()
+	h.Write([]byte(v))
+	return "overflow-" + strconv.Itoa(int(h.Sum32()%uint32(g.buckets)))
+}
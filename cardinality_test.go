@@ -0,0 +1,33 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCardinalityGuard(t *testing.T) {
+	g := NewCardinalityGuard(2, 0)
+	assert.Equal(t, map[string]string{"error": "a", "country": "nl"}, g.Guard(map[string]string{"error": "a", "country": "nl"}))
+	assert.Equal(t, map[string]string{"error": "b"}, g.Guard(map[string]string{"error": "b"}))
+	assert.Equal(t, map[string]string{"error": OverflowTagValue}, g.Guard(map[string]string{"error": "c"}))
+	assert.Equal(t, map[string]string{"error": "a"}, g.Guard(map[string]string{"error": "a"}), "known values should pass")
+	assert.Nil(t, g.Guard(nil))
+
+	hashed := NewCardinalityGuard(0, 4)
+	first := hashed.Guard(map[string]string{"error": "some long error"})["error"]
+	assert.Contains(t, []string{"overflow-0", "overflow-1", "overflow-2", "overflow-3"}, first)
+	assert.Equal(t, first, hashed.Guard(map[string]string{"error": "some long error"})["error"], "hashing should be stable")
+}
+
+func TestWithCardinalityGuard(t *testing.T) {
+	g := NewCardinalityGuard(1, 0)
+	a := Wrap(&addrConn{}, time.Second, nil, WithTags(map[string]string{"error": "a"}), WithCardinalityGuard(g))
+	defer a.Close()
+	b := Wrap(&addrConn{}, time.Second, nil, WithTags(map[string]string{"error": "b"}), WithCardinalityGuard(g))
+	defer b.Close()
+
+	assert.Equal(t, "a", a.Stats().Tags["error"])
+	assert.Equal(t, OverflowTagValue, b.Stats().Tags["error"])
+}
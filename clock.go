@@ -0,0 +1,12 @@
+package measured
+
+import (
+	"github.com/getlantern/mtime"
+)
+
+// now is the clock used for all rate and latency measurements. It reads the
+// runtime's monotonic nanosecond clock, so measurements are unaffected by wall
+// clock adjustments and are cheap enough to take on every Read and Write. Its
+// resolution depends on the platform; TestClockResolution checks that it's
+// below a millisecond where the tests run.
+var now = mtime.Now
@@ -0,0 +1,57 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockResolution(t *testing.T) {
+	last := now()
+	var minDelta time.Duration
+	for i := 0; i < 100000; i++ {
+		next := now()
+		if !assert.True(t, next >= last, "clock should be monotonic") {
+			return
+		}
+		if delta := next.Sub(last); delta > 0 && (minDelta == 0 || delta < minDelta) {
+			minDelta = delta
+		}
+		last = next
+	}
+	assert.True(t, minDelta > 0, "clock should advance")
+	assert.True(t, minDelta < time.Millisecond, "clock resolution should be below a millisecond, was %v", minDelta)
+}
+
+func TestLatency(t *testing.T) {
+	conn := Wrap(slowConn(&addrConn{}), time.Second, nil)
+	defer conn.Close()
+	conn.Write(make([]byte, 10))
+	conn.Write(make([]byte, 10))
+	conn.Read(make([]byte, 10))
+
+	stats := conn.Stats()
+	assert.True(t, stats.AvgWriteLatency >= 10*time.Millisecond)
+	assert.True(t, stats.MaxWriteLatency >= stats.AvgWriteLatency)
+	assert.True(t, stats.AvgReadLatency >= 10*time.Millisecond)
+	assert.True(t, stats.MaxReadLatency < time.Second)
+
+	fast := Wrap(&addrConn{}, time.Second, nil)
+	defer fast.Close()
+	fast.Write(make([]byte, 10))
+	assert.True(t, fast.Stats().MaxWriteLatency < stats.AvgWriteLatency, "should distinguish fast writes from slow ones")
+}
+
+func TestStartTimeAndDuration(t *testing.T) {
+	before := time.Now()
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	stats := conn.Stats()
+	assert.False(t, stats.StartTime.Before(before))
+	assert.True(t, stats.StartTime.Before(time.Now()))
+	assert.True(t, stats.Duration >= 10*time.Millisecond)
+	assert.True(t, stats.Duration <= time.Since(before))
+}
@@ -0,0 +1,28 @@
+package measured
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// countingWrite is Write for counters-only mode.
+func (c *conn) countingWrite(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.sentCount, int64(n))
+	atomic.AddInt64(&totalSent, int64(n))
+	c.afterIO(err, &c.writeErr, &c.writeTimeouts)
+	return n, err
+}
+
+// countingRead is Read for counters-only mode.
+func (c *conn) countingRead(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.recvCount, int64(n))
+	atomic.AddInt64(&totalRecv, int64(n))
+	if err == io.EOF {
+		c.terminate(ClosedRemotely, TerminatedEOF)
+	} else {
+		c.afterIO(err, &c.readErr, &c.readTimeouts)
+	}
+	return n, err
+}
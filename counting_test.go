@@ -0,0 +1,46 @@
+package measured
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountersOnly(t *testing.T) {
+	a, b := net.Pipe()
+	goroutines := runtime.NumGoroutine()
+	var finished Conn
+	conn := Wrap(a, time.Millisecond, func(c Conn) {
+		finished = c
+	}, WithCountersOnly(), WithID("counting"), WithTags(map[string]string{"mode": "counting"}))
+	assert.True(t, runtime.NumGoroutine() <= goroutines, "should not start a tracking goroutine")
+
+	go func() {
+		buf := make([]byte, 5)
+		io.ReadFull(b, buf)
+		b.Write([]byte("hello world"))
+		b.Close()
+	}()
+	conn.Write([]byte("hello"))
+	io.Copy(ioutil.Discard, conn)
+
+	stats := conn.Stats()
+	assert.Equal(t, "counting", stats.ID)
+	assert.Equal(t, 5, stats.SentTotal)
+	assert.Equal(t, 11, stats.RecvTotal)
+	assert.EqualValues(t, 0, stats.SentMax, "rates should not be calculated")
+	assert.Equal(t, TerminatedEOF, stats.Termination)
+	assert.Equal(t, map[string]string{"mode": "counting"}, stats.Tags)
+
+	assert.Nil(t, finished)
+	conn.Close()
+	conn.Close()
+	if assert.NotNil(t, finished, "onFinish should be called synchronously from Close") {
+		assert.Equal(t, 5, finished.Stats().SentTotal)
+	}
+}
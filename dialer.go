@@ -0,0 +1,123 @@
+package measured
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DialFunc dials network connections, like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type contextKey string
+
+const (
+	idKey   = contextKey("id")
+	tagsKey = contextKey("tags")
+)
+
+// ContextWithID returns a context that tells DialerMiddleware to use the given
+// ID for the conn it dials.
+func ContextWithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey, id)
+}
+
+// ContextWithTags returns a context that tells DialerMiddleware to attach the
+// given tags to the conn it dials, in addition to any tags already present in
+// ctx.
+func ContextWithTags(ctx context.Context, tags map[string]string) context.Context {
+	merged := make(map[string]string)
+	if existing, ok := ctx.Value(tagsKey).(map[string]string); ok {
+		for k, v := range existing {
+			merged[k] = v
+		}
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, tagsKey, merged)
+}
+
+// DialerMiddleware returns a middleware for layered dialers that measures
+// every conn dialed by the next dialer in the chain. The given options apply
+// to every conn. IDs and tags set on the dial context using ContextWithID and
+// ContextWithTags take precedence over options. The outcome and latency of
+// every dial are recorded and available from AggregateDials, and details of
+// how each conn was dialed are available in its Stats.Dial.
+func DialerMiddleware(rateInterval time.Duration, onFinish func(Conn), options ...Option) func(next DialFunc) DialFunc {
+	o := buildOpts(options)
+	r := reg
+	if o.instance != nil {
+		r = o.instance.reg
+	}
+	return func(next DialFunc) DialFunc {
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			trace := &dialTrace{start: now()}
+			traceCtx := context.WithValue(ctx, attemptsKey{}, trace)
+			var conn net.Conn
+			var err error
+			if o.resolver != nil {
+				conn, err = resolveAndDial(traceCtx, o.resolver, next, network, addr, trace)
+			} else {
+				conn, err = next(traceCtx, network, addr)
+			}
+			r.dials.record(now().Sub(trace.start), trace.resolveTime, err)
+			if err != nil {
+				return conn, err
+			}
+			connOptions := append(options[:len(options):len(options)], withDialStats(trace.finish(network, addr, conn)))
+			if id, ok := ctx.Value(idKey).(string); ok {
+				connOptions = append(connOptions[:len(connOptions):len(connOptions)], WithID(id))
+			}
+			if tags, ok := ctx.Value(tagsKey).(map[string]string); ok {
+				connOptions = append(connOptions[:len(connOptions):len(connOptions)], WithTags(tags))
+			}
+			return Wrap(conn, rateInterval, onFinish, connOptions...), nil
+		}
+	}
+}
+
+// Resolver resolves host names to addresses, like net.Resolver.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// resolveError indicates that a dial failed because the host couldn't be
+// resolved.
+type resolveError struct {
+	err error
+}
+
+func (e *resolveError) Error() string { return e.err.Error() }
+func (e *resolveError) Unwrap() error { return e.err }
+
+// resolveAndDial resolves the host in addr using resolver and then dials the
+// resolved addresses in order until one succeeds, recording the resolution
+// time in trace.
+func resolveAndDial(ctx context.Context, resolver Resolver, next DialFunc, network, addr string, trace *dialTrace) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return next(ctx, network, addr)
+	}
+	start := now()
+	hosts, err := resolver.LookupHost(ctx, host)
+	trace.resolveTime = now().Sub(start)
+	if err != nil {
+		return nil, &resolveError{err}
+	}
+	if len(hosts) == 0 {
+		return nil, &resolveError{&net.DNSError{Err: "no such host", Name: host, IsNotFound: true}}
+	}
+	dial := MeasureAttempts(next)
+	for _, h := range hosts {
+		var conn net.Conn
+		conn, err = dial(ctx, network, net.JoinHostPort(h, port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,101 @@
+package measured
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialerMiddleware(t *testing.T) {
+	var dialed string
+	base := DialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		return &addrConn{}, nil
+	})
+	dial := DialerMiddleware(time.Second, nil, WithTags(map[string]string{"protocol": "obfs4"}))(base)
+
+	ctx := ContextWithID(context.Background(), "client-1")
+	ctx = ContextWithTags(ctx, map[string]string{"country": "nl"})
+	ctx = ContextWithTags(ctx, map[string]string{"protocol": "tls"})
+	conn, err := dial(ctx, "tcp", "example.com:443")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(t, "example.com:443", dialed)
+	mc, ok := conn.(Conn)
+	if !assert.True(t, ok, "dialed conn should be measured") {
+		return
+	}
+	assert.Equal(t, "client-1", ID(mc))
+	assert.Equal(t, map[string]string{"country": "nl", "protocol": "tls"}, mc.Stats().Tags)
+
+	plain, err := dial(context.Background(), "tcp", "example.com:443")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer plain.Close()
+	assert.Equal(t, map[string]string{"protocol": "obfs4"}, plain.(Conn).Stats().Tags)
+}
+
+func TestDialerMiddlewareError(t *testing.T) {
+	dial := DialerMiddleware(time.Second, nil)(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("unreachable")
+	})
+	conn, err := dial(context.Background(), "tcp", "example.com:443")
+	assert.Error(t, err)
+	assert.Nil(t, conn)
+}
+
+func TestDialerMiddlewareLabels(t *testing.T) {
+	base := DialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return &addrConn{}, nil
+	})
+	labels := map[string]string{"protocol": "obfs4", "port": "443"}
+	dial := DialerMiddleware(time.Second, nil, WithLabels(labels))(base)
+	labels["port"] = "80"
+
+	a, _ := dial(ContextWithTags(context.Background(), map[string]string{"protocol": "tls"}), "tcp", "example.com:443")
+	defer a.Close()
+	b, _ := dial(context.Background(), "tcp", "example.com:443")
+	defer b.Close()
+	SetTag(a, "country", "nl")
+
+	assert.Equal(t, map[string]string{"protocol": "tls", "port": "443", "country": "nl"}, a.(Conn).Stats().Tags, "conn tags should take precedence")
+	assert.Equal(t, map[string]string{"protocol": "obfs4", "port": "443"}, b.(Conn).Stats().Tags, "labels should not be modified by conns")
+}
+
+func TestDialerMiddlewareStats(t *testing.T) {
+	i := NewInstance()
+	var fail error
+	dial := DialerMiddleware(time.Second, nil, WithInstance(i))(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		time.Sleep(5 * time.Millisecond)
+		if fail != nil {
+			return nil, fail
+		}
+		return &addrConn{}, nil
+	})
+
+	conn, err := dial(context.Background(), "tcp", "example.com:443")
+	if assert.NoError(t, err) {
+		defer conn.Close()
+	}
+	fail = &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	dial(context.Background(), "tcp", "example.com:443")
+	fail = context.DeadlineExceeded
+	dial(context.Background(), "tcp", "example.com:443")
+
+	stats := i.AggregateDials()
+	assert.Equal(t, 3, stats.Attempts)
+	assert.Equal(t, 1, stats.Successes)
+	assert.Equal(t, 2, stats.Failures)
+	assert.Equal(t, map[string]int{"refused": 1, "timeout": 1}, stats.FailuresByClass)
+	assert.True(t, stats.AvgLatency >= 5*time.Millisecond, "average latency should include each dial")
+	assert.True(t, stats.MaxLatency >= stats.AvgLatency)
+	assert.Equal(t, 1, i.Aggregate().OpenConns, "successful dial should be registered with the instance")
+}
@@ -0,0 +1,249 @@
+package measured
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/getlantern/mtime"
+)
+
+// AggregateDialStats provides statistics about establishing conns through
+// DialerMiddleware, separately from the traffic on the conns themselves.
+type AggregateDialStats struct {
+	// Attempts is the number of dials, both successful and failed
+	Attempts  int
+	Successes int
+	Failures  int
+	// FailuresByClass breaks down Failures by error class, e.g. "timeout" or
+	// "refused"
+	FailuresByClass map[string]int
+	// AvgLatency and MaxLatency cover all attempts, including failed ones
+	AvgLatency time.Duration
+	MaxLatency time.Duration
+	// AvgResolveLatency and AvgConnectLatency split AvgLatency into the time
+	// spent resolving host names and the time spent connecting. Resolution is
+	// only measured separately for dialers using WithResolver.
+	AvgResolveLatency time.Duration
+	AvgConnectLatency time.Duration
+}
+
+// dialCounters accumulates dial outcomes for a registry.
+type dialCounters struct {
+	attempts     int
+	successes    int
+	failures     map[string]int
+	totalLatency time.Duration
+	totalResolve time.Duration
+	maxLatency   time.Duration
+	mx           sync.Mutex
+}
+
+func (d *dialCounters) record(latency time.Duration, resolve time.Duration, err error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.attempts++
+	d.totalLatency += latency
+	d.totalResolve += resolve
+	if latency > d.maxLatency {
+		d.maxLatency = latency
+	}
+	if err == nil {
+		d.successes++
+		return
+	}
+	if d.failures == nil {
+		d.failures = make(map[string]int)
+	}
+	d.failures[dialErrorClass(err)]++
+}
+
+func (d *dialCounters) aggregate() *AggregateDialStats {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	stats := &AggregateDialStats{
+		Attempts:        d.attempts,
+		Successes:       d.successes,
+		Failures:        d.attempts - d.successes,
+		FailuresByClass: make(map[string]int, len(d.failures)),
+		MaxLatency:      d.maxLatency,
+	}
+	for class, count := range d.failures {
+		stats.FailuresByClass[class] = count
+	}
+	if d.attempts > 0 {
+		stats.AvgLatency = d.totalLatency / time.Duration(d.attempts)
+		stats.AvgResolveLatency = d.totalResolve / time.Duration(d.attempts)
+		stats.AvgConnectLatency = stats.AvgLatency - stats.AvgResolveLatency
+	}
+	return stats
+}
+
+// dialErrorClass is like errorClass, but also distinguishes resolution
+// failures, timeouts and cancellations, which are common when dialing.
+func dialErrorClass(err error) string {
+	var netErr net.Error
+	var resolveErr *resolveError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &resolveErr), errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return errorClass(err)
+	}
+}
+
+// AggregateDials returns statistics about all dials made through
+// DialerMiddleware.
+func AggregateDials() *AggregateDialStats {
+	return reg.dials.aggregate()
+}
+
+// DialAttempt describes a single attempt to connect to one address while
+// dialing.
+type DialAttempt struct {
+	Network string
+	Addr    string
+	// Family is "ipv4" or "ipv6", or empty if Addr isn't an IP address
+	Family string
+	// Offset is the time from the start of the dial until the attempt started
+	Offset time.Duration
+	// Duration is how long the attempt took to succeed or fail
+	Duration time.Duration
+	// Err is the error of a failed attempt. It's omitted from JSON in favor of
+	// Error, its message, because errors don't serialize meaningfully.
+	Err   error  `json:"-"`
+	Error string `json:",omitempty"`
+}
+
+// DialStats describes how a conn dialed through DialerMiddleware was
+// established.
+type DialStats struct {
+	// Attempts lists the attempts that completed before the dial returned, in
+	// the order in which they completed. Unless the dialer's per-address dial
+	// function was wrapped using MeasureAttempts, this is a single attempt
+	// covering the whole dial.
+	Attempts []DialAttempt
+	// Winner is the index in Attempts of the attempt that produced the conn
+	Winner int
+	// Duration is the total time the dial took
+	Duration time.Duration
+	// ResolveTime is the time spent resolving the host name and ConnectTime
+	// the rest of Duration. Resolution is only measured separately when
+	// dialing WithResolver; otherwise ConnectTime includes any resolution done
+	// by the dialer.
+	ResolveTime time.Duration
+	ConnectTime time.Duration
+}
+
+// Won returns the attempt that produced the conn.
+func (ds *DialStats) Won() DialAttempt {
+	return ds.Attempts[ds.Winner]
+}
+
+type attemptsKey struct{}
+
+// dialTrace collects the attempts made during one dial.
+type dialTrace struct {
+	start       mtime.Instant
+	resolveTime time.Duration
+	attempts    []DialAttempt
+	done        bool
+	mx          sync.Mutex
+}
+
+func (t *dialTrace) add(attempt DialAttempt) {
+	t.mx.Lock()
+	if !t.done {
+		t.attempts = append(t.attempts, attempt)
+	}
+	t.mx.Unlock()
+}
+
+// finish stops collecting attempts and builds DialStats for the given conn.
+func (t *dialTrace) finish(network, addr string, conn net.Conn) *DialStats {
+	t.mx.Lock()
+	t.done = true
+	attempts := t.attempts
+	t.mx.Unlock()
+	ds := &DialStats{Duration: now().Sub(t.start), ResolveTime: t.resolveTime, Winner: -1}
+	ds.ConnectTime = ds.Duration - ds.ResolveTime
+	remote := addr
+	if ra := conn.RemoteAddr(); ra != nil {
+		remote = ra.String()
+	}
+	for i, attempt := range attempts {
+		if attempt.Err == nil && attempt.Addr == remote {
+			ds.Winner = i
+		}
+	}
+	if ds.Winner == -1 {
+		for i, attempt := range attempts {
+			if attempt.Err == nil {
+				ds.Winner = i
+			}
+		}
+	}
+	if ds.Winner == -1 {
+		// the dialer didn't report attempts, treat the whole dial as one
+		attempts = append(attempts, DialAttempt{Network: network, Addr: remote, Family: addrFamily(remote), Duration: ds.Duration})
+		ds.Winner = len(attempts) - 1
+	}
+	ds.Attempts = attempts
+	return ds
+}
+
+// MeasureAttempts wraps the function that a racing dialer (e.g. one
+// implementing Happy Eyeballs) uses to connect to each individual address, so
+// that every attempt is recorded in the DialStats of the conn dialed through
+// DialerMiddleware. The context passed to dial must derive from the one given
+// to DialerMiddleware. Outside of DialerMiddleware, MeasureAttempts has no
+// effect.
+func MeasureAttempts(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		trace, ok := ctx.Value(attemptsKey{}).(*dialTrace)
+		if !ok {
+			return dial(ctx, network, addr)
+		}
+		start := now()
+		conn, err := dial(ctx, network, addr)
+		attempt := DialAttempt{
+			Network:  network,
+			Addr:     addr,
+			Family:   addrFamily(addr),
+			Offset:   start.Sub(trace.start),
+			Duration: now().Sub(start),
+			Err:      err,
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		trace.add(attempt)
+		return conn, err
+	}
+}
+
+// addrFamily returns "ipv4" or "ipv6" depending on the host in addr.
+func addrFamily(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return "ipv4"
+	default:
+		return "ipv6"
+	}
+}
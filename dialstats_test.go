@@ -0,0 +1,164 @@
+package measured
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeasureAttempts(t *testing.T) {
+	attempt := MeasureAttempts(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp6" {
+			time.Sleep(5 * time.Millisecond)
+			return nil, errors.New("no route")
+		}
+		time.Sleep(10 * time.Millisecond)
+		ip, _ := net.ResolveTCPAddr("tcp", addr)
+		return &addrConn{remote: ip}, nil
+	})
+	// a simplistic racing dialer that returns the first successful conn
+	racing := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+		results := make(chan result, 2)
+		go func() {
+			conn, err := attempt(ctx, "tcp6", "[2001:db8::1]:443")
+			results <- result{conn, err}
+		}()
+		go func() {
+			conn, err := attempt(ctx, "tcp4", "192.0.2.1:443")
+			results <- result{conn, err}
+		}()
+		var err error
+		for i := 0; i < 2; i++ {
+			r := <-results
+			if r.err == nil {
+				return r.conn, nil
+			}
+			err = r.err
+		}
+		return nil, err
+	}
+
+	conn, err := DialerMiddleware(time.Second, nil, WithInstance(NewInstance()))(racing)(context.Background(), "tcp", "example.com:443")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	ds := conn.(Conn).Stats().Dial
+	if !assert.NotNil(t, ds) || !assert.Len(t, ds.Attempts, 2) {
+		return
+	}
+	assert.Equal(t, "ipv6", ds.Attempts[0].Family)
+	assert.Error(t, ds.Attempts[0].Err)
+	b, err := json.Marshal(ds)
+	if assert.NoError(t, err) {
+		var decoded DialStats
+		assert.NoError(t, json.Unmarshal(b, &decoded))
+		assert.Equal(t, "no route", decoded.Attempts[0].Error)
+	}
+	won := ds.Won()
+	assert.Equal(t, "192.0.2.1:443", won.Addr)
+	assert.Equal(t, "ipv4", won.Family)
+	assert.NoError(t, won.Err)
+	assert.True(t, won.Duration >= 10*time.Millisecond)
+	assert.True(t, ds.Duration >= won.Duration)
+}
+
+func TestDialStatsWithoutAttempts(t *testing.T) {
+	dial := DialerMiddleware(time.Second, nil)(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return &addrConn{remote: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80}}, nil
+	})
+	conn, err := dial(context.Background(), "tcp", "example.com:80")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	ds := conn.(Conn).Stats().Dial
+	if assert.Len(t, ds.Attempts, 1) {
+		assert.Equal(t, "[2001:db8::2]:80", ds.Won().Addr)
+		assert.Equal(t, "ipv6", ds.Won().Family)
+	}
+
+	// MeasureAttempts is transparent outside of DialerMiddleware
+	_, err = MeasureAttempts(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("boom")
+	})(context.Background(), "tcp", "example.com:80")
+	assert.EqualError(t, err, "boom")
+}
+
+func TestAddrFamily(t *testing.T) {
+	assert.Equal(t, "ipv4", addrFamily("192.0.2.1:443"))
+	assert.Equal(t, "ipv6", addrFamily("[2001:db8::1]:443"))
+	assert.Equal(t, "ipv4", addrFamily("::ffff:192.0.2.1"))
+	assert.Equal(t, "", addrFamily("example.com:443"))
+}
+
+type fakeResolver struct {
+	delay time.Duration
+	hosts []string
+	err   error
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	time.Sleep(r.delay)
+	return r.hosts, r.err
+}
+
+func TestWithResolver(t *testing.T) {
+	i := NewInstance()
+	var dialed []string
+	next := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		time.Sleep(5 * time.Millisecond)
+		if addr == "192.0.2.1:443" {
+			return nil, errors.New("unreachable")
+		}
+		ip, _ := net.ResolveTCPAddr("tcp", addr)
+		return &addrConn{remote: ip}, nil
+	}
+	resolver := &fakeResolver{delay: 20 * time.Millisecond, hosts: []string{"192.0.2.1", "192.0.2.2"}}
+	dial := DialerMiddleware(time.Second, nil, WithInstance(i), WithResolver(resolver))(next)
+
+	conn, err := dial(context.Background(), "tcp", "example.com:443")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(t, []string{"192.0.2.1:443", "192.0.2.2:443"}, dialed)
+	ds := conn.(Conn).Stats().Dial
+	assert.True(t, ds.ResolveTime >= 20*time.Millisecond, "resolve time should be measured")
+	assert.True(t, ds.ConnectTime >= 10*time.Millisecond, "connect time should cover both attempts")
+	assert.True(t, ds.ConnectTime < ds.ResolveTime)
+	assert.Len(t, ds.Attempts, 2)
+	assert.Equal(t, "192.0.2.2:443", ds.Won().Addr)
+
+	dialed = nil
+	ipConn, err := dial(context.Background(), "tcp", "192.0.2.3:443")
+	if assert.NoError(t, err) {
+		defer ipConn.Close()
+		assert.Equal(t, time.Duration(0), ipConn.(Conn).Stats().Dial.ResolveTime, "IP addresses should not be resolved")
+	}
+	assert.Equal(t, []string{"192.0.2.3:443"}, dialed)
+
+	resolver.err = errors.New("servfail")
+	_, err = dial(context.Background(), "tcp", "example.com:443")
+	assert.EqualError(t, err, "servfail")
+	resolver.err = nil
+	resolver.hosts = nil
+	_, err = dial(context.Background(), "tcp", "example.com:443")
+	assert.Error(t, err)
+
+	stats := i.AggregateDials()
+	assert.Equal(t, 4, stats.Attempts)
+	assert.Equal(t, map[string]int{"dns": 2}, stats.FailuresByClass)
+	assert.True(t, stats.AvgResolveLatency > 0)
+	assert.Equal(t, stats.AvgLatency, stats.AvgResolveLatency+stats.AvgConnectLatency)
+}
@@ -0,0 +1,41 @@
+package measured
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// DumpConnections writes a table of all live measured connections to w,
+// oldest first, listing each connection's ID, remote address, age, bytes
+// transferred, current rates (bytes per second) and last unexpected error.
+func DumpConnections(w io.Writer) error {
+	return dumpConnections(w, reg)
+}
+
+func dumpConnections(w io.Writer, r *registry) error {
+	conns := r.live()
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].startTime.Before(conns[j].startTime)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tREMOTE\tAGE\tSENT\tRECV\tSENT/S\tRECV/S\tLAST ERROR")
+	for _, c := range conns {
+		remote := "-"
+		if addr := c.RemoteAddr(); addr != nil {
+			remote = addr.String()
+		}
+		lastErr := "-"
+		if err := c.lastError(); err != nil {
+			lastErr = err.Error()
+		}
+		sent, _, _, _ := c.sent.get()
+		recv, _, _, _ := c.recv.get()
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.0f\t%.0f\t%s\n",
+			c.id, remote, c.age().Round(time.Millisecond), sent, recv, c.sent.rate(), c.recv.rate(), lastErr)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,48 @@
+package measured
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpConnections(t *testing.T) {
+	conn := Wrap(&errConn{addrConn: addrConn{remote: &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 7654}}, err: errors.New("stuck tunnel")}, time.Second, nil, WithID("dumped"))
+	defer conn.Close()
+	conn.Read(make([]byte, 10))
+
+	var buf bytes.Buffer
+	if !assert.NoError(t, DumpConnections(&buf)) {
+		return
+	}
+	lines := strings.Split(buf.String(), "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "ID"))
+	found := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "dumped ") {
+			found = true
+			assert.Contains(t, line, "1.2.3.4:7654")
+			assert.Contains(t, line, "stuck tunnel")
+		}
+	}
+	assert.True(t, found, "conn should be included in dump")
+}
+
+func TestIDs(t *testing.T) {
+	conn1 := Wrap(&addrConn{}, time.Second, nil)
+	defer conn1.Close()
+	conn2 := Wrap(&addrConn{}, time.Second, nil)
+	defer conn2.Close()
+	conn3 := Wrap(&addrConn{}, time.Second, nil, WithID("custom"))
+	defer conn3.Close()
+	assert.NotEmpty(t, ID(conn1))
+	assert.NotEqual(t, ID(conn1), ID(conn2))
+	assert.Equal(t, "custom", ID(conn3))
+	assert.Equal(t, "custom", ID(&decoratedConn{conn3}))
+	assert.Empty(t, ID(&addrConn{}), "unmeasured conns have no ID")
+}
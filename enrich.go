@@ -0,0 +1,41 @@
+package measured
+
+import (
+	"net"
+)
+
+// Enricher looks up additional tags for a conn based on its remote address,
+// for example the country and ASN from a GeoIP database supplied by the
+// caller.
+type Enricher interface {
+	Enrich(remoteAddr net.Addr) map[string]string
+}
+
+// EnricherFunc adapts a function to an Enricher.
+type EnricherFunc func(remoteAddr net.Addr) map[string]string
+
+// Enrich implements the Enricher interface.
+func (fn EnricherFunc) Enrich(remoteAddr net.Addr) map[string]string {
+	return fn(remoteAddr)
+}
+
+// enrich adds tags from the configured enrichers, without overwriting tags that
+// have already been set on the conn.
+func (c *conn) enrich() {
+	for _, e := range c.enrichers {
+		tags := e.Enrich(c.RemoteAddr())
+		if len(tags) == 0 {
+			continue
+		}
+		c.metaMx.Lock()
+		if c.tags == nil {
+			c.tags = make(map[string]string, len(tags))
+		}
+		for k, v := range tags {
+			if _, found := c.tags[k]; !found {
+				c.tags[k] = v
+			}
+		}
+		c.metaMx.Unlock()
+	}
+}
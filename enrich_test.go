@@ -0,0 +1,30 @@
+package measured
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnricher(t *testing.T) {
+	geo := EnricherFunc(func(remoteAddr net.Addr) map[string]string {
+		if remoteAddr.(*net.TCPAddr).IP.Equal(net.ParseIP("1.2.3.4")) {
+			return map[string]string{"country": "nl", "asn": "AS64496"}
+		}
+		return nil
+	})
+	finished := make(chan *Stats, 1)
+	conn := Wrap(&addrConn{remote: &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 443}}, 10*time.Millisecond, func(c Conn) {
+		finished <- c.Stats()
+	}, WithTags(map[string]string{"country": "override"}), WithEnricher(geo))
+	conn.Close()
+
+	select {
+	case stats := <-finished:
+		assert.Equal(t, map[string]string{"country": "override", "asn": "AS64496"}, stats.Tags)
+	case <-time.After(1 * time.Second):
+		t.Fatal("onFinish not called")
+	}
+}
@@ -0,0 +1,45 @@
+package measured
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"syscall"
+)
+
+var publishOnce sync.Once
+
+// PublishExpvars publishes aggregate counters for all measured conns under the
+// expvar "measured", so that they're served from /debug/vars. It is safe to
+// call PublishExpvars more than once.
+func PublishExpvars() {
+	publishOnce.Do(func() {
+		expvar.Publish("measured", expvar.Func(func() interface{} {
+			agg := reg.aggregate()
+			return map[string]interface{}{
+				"open_conns":      agg.OpenConns,
+				"sent_total":      agg.SentTotal,
+				"recv_total":      agg.RecvTotal,
+				"active_trackers": ActiveTrackers(),
+				"errors":          reg.errorCounts(),
+				"dials":           reg.dials.aggregate(),
+			}
+		}))
+	})
+}
+
+// errorClass classifies unexpected errors for the purpose of counting them.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.EPIPE):
+		return "broken_pipe"
+	case errors.Is(err, syscall.ECONNABORTED):
+		return "aborted"
+	default:
+		return "other"
+	}
+}
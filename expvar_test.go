@@ -0,0 +1,54 @@
+package measured
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishExpvars(t *testing.T) {
+	PublishExpvars()
+	PublishExpvars()
+
+	conn := Wrap(&errConn{err: fmt.Errorf("read: %w", syscall.ECONNRESET)}, time.Second, nil)
+	defer conn.Close()
+	conn.Read(make([]byte, 10))
+
+	v := expvar.Get("measured")
+	if !assert.NotNil(t, v) {
+		return
+	}
+	vars := make(map[string]interface{})
+	if !assert.NoError(t, json.Unmarshal([]byte(v.String()), &vars)) {
+		return
+	}
+	assert.Contains(t, vars, "open_conns")
+	assert.Contains(t, vars, "sent_total")
+	assert.Contains(t, vars, "recv_total")
+	assert.Contains(t, vars, "active_trackers")
+	errs, _ := vars["errors"].(map[string]interface{})
+	assert.True(t, errs["reset"].(float64) >= 1)
+}
+
+func TestErrorClass(t *testing.T) {
+	assert.Equal(t, "reset", errorClass(&net.OpError{Op: "read", Err: syscall.ECONNRESET}))
+	assert.Equal(t, "refused", errorClass(syscall.ECONNREFUSED))
+	assert.Equal(t, "broken_pipe", errorClass(syscall.EPIPE))
+	assert.Equal(t, "other", errorClass(errors.New("boom")))
+}
+
+// errConn is a net.Conn whose reads and writes fail with err.
+type errConn struct {
+	addrConn
+	err error
+}
+
+func (c *errConn) Read(b []byte) (int, error)  { return 0, c.err }
+func (c *errConn) Write(b []byte) (int, error) { return 0, c.err }
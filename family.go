@@ -0,0 +1,54 @@
+package measured
+
+import (
+	"net"
+)
+
+// FamilyTag is the tag under which WithFamilyTag reports a conn's address
+// family.
+const FamilyTag = "family"
+
+// connFamily returns the address family of c, i.e. "tcp4", "tcp6", "udp4",
+// "udp6" or "unix", based on its remote address and falling back to its local
+// address. For other kinds of addresses, like Windows named pipes, it returns
+// the address's network name.
+func connFamily(c net.Conn) string {
+	addr := c.RemoteAddr()
+	if isNilAddr(addr) {
+		addr = c.LocalAddr()
+	}
+	if isNilAddr(addr) {
+		return ""
+	}
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if a.IP.To4() != nil {
+			return "tcp4"
+		}
+		return "tcp6"
+	case *net.UDPAddr:
+		if a.IP.To4() != nil {
+			return "udp4"
+		}
+		return "udp6"
+	case *net.UnixAddr:
+		return "unix"
+	default:
+		return addr.Network()
+	}
+}
+
+func isNilAddr(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case nil:
+		return true
+	case *net.TCPAddr:
+		return a == nil
+	case *net.UDPAddr:
+		return a == nil
+	case *net.UnixAddr:
+		return a == nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,97 @@
+package measured
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTrip wraps the client side of a conn over the given listener with
+// WithFamilyTag and WithTCPInfo, sends and echoes some data, and returns the
+// client's Stats after closing it.
+func roundTrip(t *testing.T, l net.Listener) *Stats {
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	raw, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	finished := make(chan *Stats, 1)
+	conn := Wrap(raw, 10*time.Millisecond, func(c Conn) {
+		finished <- c.Stats()
+	}, WithFamilyTag(), WithTCPInfo())
+	_, err = conn.Write([]byte("hello"))
+	assert.NoError(t, err)
+	b := make([]byte, 5)
+	_, err = io.ReadFull(conn, b)
+	assert.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+	conn.Close()
+	stats := <-finished
+	assert.Nil(t, conn.FirstError())
+	assert.Equal(t, 5, stats.SentTotal)
+	assert.Equal(t, 5, stats.RecvTotal)
+	return stats
+}
+
+func TestFamilyTCP4(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	if stats := roundTrip(t, l); stats != nil {
+		assert.Equal(t, "tcp4", stats.Tags[FamilyTag])
+	}
+}
+
+func TestFamilyTCP6(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 unavailable: %v", err)
+	}
+	defer l.Close()
+	if stats := roundTrip(t, l); stats != nil {
+		assert.Equal(t, "tcp6", stats.Tags[FamilyTag])
+	}
+}
+
+func TestFamilyUnix(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "measured.sock"))
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer l.Close()
+	if stats := roundTrip(t, l); stats != nil {
+		assert.Equal(t, "unix", stats.Tags[FamilyTag])
+		assert.Equal(t, time.Duration(0), stats.RTT, "unix sockets have no TCP_INFO")
+	}
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+func TestConnFamily(t *testing.T) {
+	assert.Equal(t, "pipe", connFamily(&addrConn{remote: pipeAddr(`\\.\pipe\measured`)}), "named pipes should report their network")
+	assert.Equal(t, "udp6", connFamily(&addrConn{remote: &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}}))
+	assert.Equal(t, "", connFamily(&addrConn{remote: (*net.TCPAddr)(nil)}))
+
+	conn := Wrap(&addrConn{remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}, time.Second, nil, WithFamilyTag(), WithTags(map[string]string{FamilyTag: "custom"}))
+	defer conn.Close()
+	assert.Equal(t, "custom", conn.Stats().Tags[FamilyTag], "explicit tags should take precedence")
+	plain := Wrap(&addrConn{remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}, time.Second, nil)
+	defer plain.Close()
+	assert.Empty(t, plain.Stats().Tags[FamilyTag])
+}
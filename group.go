@@ -0,0 +1,195 @@
+package measured
+
+import (
+	"sync"
+	"time"
+)
+
+// Group aggregates the conns that make up the paths of a multipath tunnel. It
+// reports combined throughput, each path's share of the traffic and failovers
+// from one path to another.
+//
+// The Group considers one path to be primary, initially the first path added.
+// When the primary path's conn finishes while other paths are still open, the
+// Group fails over to the open path that has carried the most traffic. Callers
+// that choose paths themselves can report their choice using SetPrimary.
+type Group struct {
+	paths      []*groupPath
+	primary    *groupPath
+	failovers  []Failover
+	onFailover func(Failover)
+	mx         sync.Mutex
+}
+
+type groupPath struct {
+	name  string
+	conn  Conn
+	final *Stats
+}
+
+// Failover records a switch of the primary path of a Group.
+type Failover struct {
+	// From is the name of the previous primary path
+	From string
+	// To is the name of the new primary path, or empty if no path was left
+	To   string
+	Time time.Time
+	// Err is the first error of the previous primary path's conn, if it failed
+	Err error
+}
+
+// PathStats provides statistics about one path of a Group.
+type PathStats struct {
+	Name      string
+	Primary   bool
+	Open      bool
+	SentTotal int
+	RecvTotal int
+	// SentRate and RecvRate are the rates calculated at the most recent rate
+	// interval (the average rates for Conns that aren't measured by this
+	// package), or 0 if the path is no longer open
+	SentRate float64
+	RecvRate float64
+	// Share is the percentage of the Group's traffic (sent plus received) that
+	// was carried by this path
+	Share float64
+}
+
+// GroupStats provides statistics about a Group.
+type GroupStats struct {
+	SentTotal int
+	RecvTotal int
+	// SentRate and RecvRate are the combined rates of all open paths
+	SentRate  float64
+	RecvRate  float64
+	Paths     []PathStats
+	Failovers []Failover
+}
+
+// NewGroup creates an empty Group. If onFailover is not nil, it's called
+// whenever the primary path changes.
+func NewGroup(onFailover func(Failover)) *Group {
+	return &Group{onFailover: onFailover}
+}
+
+// AddPath adds a measured conn to the Group as a path with the given name.
+func (g *Group) AddPath(name string, c Conn) {
+	p := &groupPath{name: name, conn: c}
+	g.mx.Lock()
+	g.paths = append(g.paths, p)
+	if g.primary == nil {
+		g.primary = p
+	}
+	g.mx.Unlock()
+	if mc, ok := c.(*conn); ok {
+		mc.rewrap(func(Conn) { g.finished(p) }, &opts{})
+	}
+}
+
+// SetPrimary makes the path with the given name primary, recording a Failover
+// if a different path was primary. It has no effect if there is no such path.
+func (g *Group) SetPrimary(name string) {
+	g.mx.Lock()
+	var failover *Failover
+	for _, p := range g.paths {
+		if p.name == name && p != g.primary {
+			failover = g.failoverLocked(p, nil)
+			break
+		}
+	}
+	g.mx.Unlock()
+	g.notify(failover)
+}
+
+func (g *Group) finished(p *groupPath) {
+	stats := p.conn.Stats()
+	g.mx.Lock()
+	p.final = stats
+	var failover *Failover
+	if p == g.primary {
+		var next *groupPath
+		nextTotal := -1
+		for _, candidate := range g.paths {
+			if candidate.final != nil {
+				continue
+			}
+			sent, recv, _, _ := pathTraffic(candidate.conn)
+			if total := sent + recv; total > nextTotal {
+				next, nextTotal = candidate, total
+			}
+		}
+		failover = g.failoverLocked(next, p.conn.FirstError())
+	}
+	g.mx.Unlock()
+	g.notify(failover)
+}
+
+// failoverLocked switches the primary path to next. g.mx must be held.
+func (g *Group) failoverLocked(next *groupPath, err error) *Failover {
+	failover := Failover{Time: time.Now(), Err: err}
+	if g.primary != nil {
+		failover.From = g.primary.name
+	}
+	if next != nil {
+		failover.To = next.name
+	}
+	g.primary = next
+	g.failovers = append(g.failovers, failover)
+	return &failover
+}
+
+func (g *Group) notify(failover *Failover) {
+	if failover != nil && g.onFailover != nil {
+		g.onFailover(*failover)
+	}
+}
+
+// Stats gets the current stats of the Group.
+func (g *Group) Stats() *GroupStats {
+	g.mx.Lock()
+	paths := make([]groupPath, len(g.paths))
+	primary := -1
+	for i, p := range g.paths {
+		paths[i] = *p
+		if p == g.primary {
+			primary = i
+		}
+	}
+	failovers := make([]Failover, len(g.failovers))
+	copy(failovers, g.failovers)
+	g.mx.Unlock()
+
+	stats := &GroupStats{Paths: make([]PathStats, len(paths)), Failovers: failovers}
+	for i, p := range paths {
+		ps := PathStats{Name: p.name, Primary: i == primary}
+		if p.final != nil {
+			ps.SentTotal, ps.RecvTotal = p.final.SentTotal, p.final.RecvTotal
+		} else {
+			ps.Open = true
+			ps.SentTotal, ps.RecvTotal, ps.SentRate, ps.RecvRate = pathTraffic(p.conn)
+		}
+		stats.SentTotal += ps.SentTotal
+		stats.RecvTotal += ps.RecvTotal
+		stats.SentRate += ps.SentRate
+		stats.RecvRate += ps.RecvRate
+		stats.Paths[i] = ps
+	}
+	if total := stats.SentTotal + stats.RecvTotal; total > 0 {
+		for i := range stats.Paths {
+			ps := &stats.Paths[i]
+			ps.Share = 100 * float64(ps.SentTotal+ps.RecvTotal) / float64(total)
+		}
+	}
+	return stats
+}
+
+// pathTraffic returns the totals and current rates of c. For Conns that aren't
+// measured by this package, it falls back to their average rates.
+func pathTraffic(c Conn) (sent int, recv int, sentRate float64, recvRate float64) {
+	if mc, ok := findConn(c); ok {
+		view := StatsView{mc}
+		return view.SentTotal(), view.RecvTotal(), view.SentRate(), view.RecvRate()
+	}
+	stats := c.Stats()
+	return stats.SentTotal, stats.RecvTotal, stats.SentAvg, stats.RecvAvg
+}
@@ -0,0 +1,90 @@
+package measured
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroup(t *testing.T) {
+	failovers := make(chan Failover, 10)
+	g := NewGroup(func(f Failover) {
+		failovers <- f
+	})
+	wifi := Wrap(&addrConn{}, 10*time.Millisecond, nil)
+	lte := Wrap(&addrConn{}, 10*time.Millisecond, nil)
+	backup := Wrap(&addrConn{}, 10*time.Millisecond, nil)
+	defer lte.Close()
+	defer backup.Close()
+	g.AddPath("wifi", wifi)
+	g.AddPath("lte", lte)
+	g.AddPath("backup", backup)
+
+	wifi.Write(make([]byte, 600))
+	lte.Write(make([]byte, 300))
+	lte.Read(make([]byte, 50))
+	backup.Write(make([]byte, 50))
+	time.Sleep(30 * time.Millisecond)
+
+	stats := g.Stats()
+	assert.Equal(t, 950, stats.SentTotal)
+	assert.Equal(t, 50, stats.RecvTotal)
+	if assert.Len(t, stats.Paths, 3) {
+		assert.True(t, stats.Paths[0].Primary)
+		assert.InDelta(t, 60, stats.Paths[0].Share, 0.01)
+		assert.InDelta(t, 35, stats.Paths[1].Share, 0.01)
+		assert.InDelta(t, 5, stats.Paths[2].Share, 0.01)
+	}
+	assert.Empty(t, stats.Failovers)
+
+	wifi.Close()
+	select {
+	case f := <-failovers:
+		assert.Equal(t, "wifi", f.From)
+		assert.Equal(t, "lte", f.To, "should fail over to the path with the most traffic")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no failover")
+	}
+	stats = g.Stats()
+	assert.False(t, stats.Paths[0].Open)
+	assert.Equal(t, 600, stats.Paths[0].SentTotal, "closed paths should keep their totals")
+	assert.True(t, stats.Paths[1].Primary)
+
+	g.SetPrimary("backup")
+	g.SetPrimary("backup")
+	g.SetPrimary("unknown")
+	stats = g.Stats()
+	if assert.Len(t, stats.Failovers, 2) {
+		assert.Equal(t, "lte", stats.Failovers[1].From)
+		assert.Equal(t, "backup", stats.Failovers[1].To)
+	}
+	assert.True(t, stats.Paths[2].Primary)
+}
+
+// foreignConn is a Conn implemented outside of this package, like a mock.
+type foreignConn struct {
+	Conn
+	stats *Stats
+}
+
+func (c *foreignConn) Stats() *Stats     { return c.stats }
+func (c *foreignConn) Wrapped() net.Conn { return nil }
+func (c *foreignConn) Unwrap() net.Conn  { return nil }
+func (c *foreignConn) NetConn() net.Conn { return nil }
+
+func TestGroupForeignConn(t *testing.T) {
+	g := NewGroup(nil)
+	g.AddPath("mock", &foreignConn{stats: &Stats{SentTotal: 30, RecvTotal: 10, SentAvg: 5}})
+	measured := Wrap(&addrConn{}, time.Second, nil)
+	defer measured.Close()
+	measured.Write(make([]byte, 60))
+	g.AddPath("measured", measured)
+
+	stats := g.Stats()
+	assert.Equal(t, 90, stats.SentTotal)
+	assert.Equal(t, 10, stats.RecvTotal)
+	assert.EqualValues(t, 5, stats.Paths[0].SentRate, "should fall back to average rates")
+	assert.InDelta(t, 40, stats.Paths[0].Share, 0.01)
+}
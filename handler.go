@@ -0,0 +1,81 @@
+package measured
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+type connSnapshot struct {
+	ID         string `json:"id"`
+	LocalAddr  string `json:"local_addr"`
+	RemoteAddr string `json:"remote_addr"`
+	FirstError string `json:"first_error,omitempty"`
+	Stats      *Stats `json:"stats"`
+}
+
+type snapshot struct {
+	Aggregate    *AggregateStats `json:"aggregate"`
+	Conns        []*connSnapshot `json:"conns"`
+	HeavyHitters []HeavyHitter   `json:"heavy_hitters,omitempty"`
+}
+
+// defaultHeavyHitters is the number of heavy hitters included in snapshots
+// when the request doesn't specify ?top.
+const defaultHeavyHitters = 10
+
+// Handler returns an http.Handler that serves a snapshot of all live measured
+// conns and aggregate stats as JSON. The snapshot also includes the top buckets
+// of any HeavyHitters that conns were wrapped with, as many as given by ?top
+// (10 by default). Requesting it with ?format=prometheus serves the aggregate
+// stats in the Prometheus text format instead.
+func Handler() http.Handler {
+	return handlerFor(reg)
+}
+
+func handlerFor(r *registry) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("format") == "prometheus" {
+			servePrometheus(resp, r)
+			return
+		}
+		top, err := strconv.Atoi(req.URL.Query().Get("top"))
+		if err != nil || top < 0 {
+			top = defaultHeavyHitters
+		}
+		serveJSON(resp, r, top)
+	})
+}
+
+func serveJSON(resp http.ResponseWriter, r *registry, top int) {
+	snap := &snapshot{Aggregate: r.aggregate(), HeavyHitters: r.topHeavyHitters(top)}
+	for _, c := range r.live() {
+		cs := &connSnapshot{ID: c.id, Stats: c.Stats()}
+		if addr := c.LocalAddr(); addr != nil {
+			cs.LocalAddr = addr.String()
+		}
+		if addr := c.RemoteAddr(); addr != nil {
+			cs.RemoteAddr = addr.String()
+		}
+		if err := c.FirstError(); err != nil {
+			cs.FirstError = err.Error()
+		}
+		snap.Conns = append(snap.Conns, cs)
+	}
+	// Show the oldest conns first
+	sort.Slice(snap.Conns, func(i, j int) bool {
+		return snap.Conns[i].Stats.Duration > snap.Conns[j].Stats.Duration
+	})
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(snap)
+}
+
+func servePrometheus(resp http.ResponseWriter, r *registry) {
+	agg := r.aggregate()
+	resp.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(resp, "# TYPE measured_open_conns gauge\nmeasured_open_conns %d\n", agg.OpenConns)
+	fmt.Fprintf(resp, "# TYPE measured_sent_bytes_total counter\nmeasured_sent_bytes_total %d\n", agg.SentTotal)
+	fmt.Fprintf(resp, "# TYPE measured_recv_bytes_total counter\nmeasured_recv_bytes_total %d\n", agg.RecvTotal)
+}
@@ -0,0 +1,83 @@
+package measured
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	before := Aggregate()
+	conn := Wrap(&addrConn{remote: &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 6543}}, time.Second, nil)
+	defer conn.Close()
+	conn.Write([]byte("12345"))
+
+	after := Aggregate()
+	assert.Equal(t, before.OpenConns+1, after.OpenConns)
+	assert.Equal(t, before.SentTotal+5, after.SentTotal)
+
+	resp := httptest.NewRecorder()
+	Handler().ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	snap := &snapshot{}
+	if !assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), snap)) {
+		return
+	}
+	found := false
+	for _, cs := range snap.Conns {
+		if cs.RemoteAddr == "1.2.3.4:6543" {
+			found = true
+			assert.Equal(t, 5, cs.Stats.SentTotal)
+		}
+	}
+	assert.True(t, found, "live conn should be included in snapshot")
+
+	resp = httptest.NewRecorder()
+	Handler().ServeHTTP(resp, httptest.NewRequest("GET", "/?format=prometheus", nil))
+	assert.True(t, strings.Contains(resp.Body.String(), "measured_open_conns "))
+	assert.True(t, strings.Contains(resp.Body.String(), "measured_sent_bytes_total "))
+}
+
+func TestHandlerHeavyHitters(t *testing.T) {
+	i := NewInstance()
+	h := NewHeavyHitters(ByID, 10, time.Hour, nil)
+	finished := make(chan interface{})
+	for _, id := range []string{"small", "big"} {
+		conn := i.Wrap(&addrConn{}, time.Second, func(c Conn) { finished <- nil }, WithID(id), WithHeavyHitters(h))
+		if id == "big" {
+			conn.Write([]byte("12345"))
+		}
+		conn.Write([]byte("12345"))
+		conn.Close()
+		<-finished
+	}
+
+	get := func() *snapshot {
+		resp := httptest.NewRecorder()
+		i.Handler().ServeHTTP(resp, httptest.NewRequest("GET", "/?top=1", nil))
+		snap := &snapshot{}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), snap))
+		return snap
+	}
+	assert.Equal(t, []HeavyHitter{{Key: "big", Bytes: 10}}, get().HeavyHitters)
+	h.Stop()
+	assert.Empty(t, get().HeavyHitters, "stopped trackers should be left out")
+}
+
+func TestRegistryRemovesFinished(t *testing.T) {
+	finished := make(chan interface{})
+	conn := Wrap(&addrConn{}, time.Second, func(c Conn) { close(finished) })
+	conn.Write([]byte("12345"))
+	assert.Contains(t, reg.live(), conn)
+	before := Aggregate()
+	conn.Close()
+	<-finished
+
+	assert.NotContains(t, reg.live(), conn)
+	assert.True(t, Aggregate().SentTotal >= before.SentTotal, "finished conns should still count towards totals")
+}
@@ -0,0 +1,146 @@
+package measured
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// HeavyHitter is one of the top traffic consumers tracked by HeavyHitters.
+type HeavyHitter struct {
+	Key string
+	// Bytes is the estimated number of bytes sent and received. It may
+	// overestimate the actual traffic by up to Error bytes.
+	Bytes int
+	Error int
+}
+
+// HeavyHitters continuously maintains the top traffic consumers among many
+// conns in constant space using the SpaceSaving algorithm. Conns are added by
+// wrapping them WithHeavyHitters.
+type HeavyHitters struct {
+	bucket   BucketFunc
+	capacity int
+	counters map[string]*HeavyHitter
+	mx       sync.Mutex
+	report   func([]HeavyHitter)
+	stopCh   chan interface{}
+	stopOnce sync.Once
+	stopWg   sync.WaitGroup
+}
+
+// NewHeavyHitters constructs a HeavyHitters that buckets traffic using the
+// given BucketFunc (e.g. ByID or ByIP) and tracks at most capacity buckets.
+// Any bucket whose traffic exceeds 1/capacity of the total is guaranteed to be
+// tracked. If report is non-nil, it's called with the current top buckets every
+// interval. A capacity below 1 is raised to 1 and a zero or negative interval
+// is replaced with DefaultReportInterval.
+func NewHeavyHitters(bucket BucketFunc, capacity int, interval time.Duration, report func([]HeavyHitter)) *HeavyHitters {
+	if capacity < 1 {
+		capacity = 1
+	}
+	interval = validReportInterval(interval)
+	h := &HeavyHitters{
+		bucket:   bucket,
+		capacity: capacity,
+		counters: make(map[string]*HeavyHitter, capacity),
+		report:   report,
+		stopCh:   make(chan interface{}),
+	}
+	if report != nil {
+		h.stopWg.Add(1)
+		go h.run(interval)
+	}
+	return h
+}
+
+func (h *HeavyHitters) run(interval time.Duration) {
+	defer h.stopWg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+			if top := h.Top(h.capacity); len(top) > 0 {
+				safely("heavy hitters report", func() { h.report(top) })
+			}
+		}
+	}
+}
+
+func (h *HeavyHitters) bucketOf(c Conn) string {
+	return h.bucket(c)
+}
+
+func (h *HeavyHitters) add(bucket string, sent int, recv int) {
+	n := sent + recv
+	if n == 0 {
+		return
+	}
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if counter, found := h.counters[bucket]; found {
+		counter.Bytes += n
+		return
+	}
+	if len(h.counters) < h.capacity {
+		h.counters[bucket] = &HeavyHitter{Key: bucket, Bytes: n}
+		return
+	}
+	// Replace the smallest counter, attributing its count to the new bucket as
+	// potential error
+	var min *HeavyHitter
+	for _, counter := range h.counters {
+		if min == nil || counter.Bytes < min.Bytes {
+			min = counter
+		}
+	}
+	delete(h.counters, min.Key)
+	min.Key = bucket
+	min.Error = min.Bytes
+	min.Bytes += n
+	h.counters[bucket] = min
+}
+
+// Top returns up to n of the top traffic consumers, largest first.
+func (h *HeavyHitters) Top(n int) []HeavyHitter {
+	h.mx.Lock()
+	top := make([]HeavyHitter, 0, len(h.counters))
+	for _, counter := range h.counters {
+		top = append(top, *counter)
+	}
+	h.mx.Unlock()
+	return topN(top, n)
+}
+
+// topN sorts hitters largest first and returns up to n of them.
+func topN(hitters []HeavyHitter, n int) []HeavyHitter {
+	sort.Slice(hitters, func(i, j int) bool {
+		if hitters[i].Bytes == hitters[j].Bytes {
+			return hitters[i].Key < hitters[j].Key
+		}
+		return hitters[i].Bytes > hitters[j].Bytes
+	})
+	if len(hitters) > n {
+		hitters = hitters[:n]
+	}
+	return hitters
+}
+
+// stopped checks whether Stop has been called.
+func (h *HeavyHitters) stopped() bool {
+	select {
+	case <-h.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops periodic reporting. It's safe to call Stop more than once.
+func (h *HeavyHitters) Stop() {
+	h.stopOnce.Do(func() { close(h.stopCh) })
+	h.stopWg.Wait()
+}
@@ -0,0 +1,89 @@
+package measured
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeavyHitters(t *testing.T) {
+	h := NewHeavyHitters(ByID, 3, time.Hour, nil)
+	for i := 0; i < 10; i++ {
+		h.add(fmt.Sprintf("small%d", i), 1, 0)
+	}
+	h.add("big", 500, 500)
+	h.add("medium", 200, 0)
+	for i := 0; i < 10; i++ {
+		h.add(fmt.Sprintf("tiny%d", i), 0, 1)
+	}
+	h.add("big", 100, 0)
+
+	top := h.Top(2)
+	if assert.Len(t, top, 2) {
+		assert.Equal(t, "big", top[0].Key)
+		assert.True(t, top[0].Bytes >= 1100 && top[0].Bytes-top[0].Error <= 1100)
+		assert.Equal(t, "medium", top[1].Key)
+	}
+	assert.Len(t, h.Top(10), 3, "should track at most capacity buckets")
+}
+
+func TestHeavyHittersInvalidArgs(t *testing.T) {
+	h := NewHeavyHitters(ByID, 0, 0, func([]HeavyHitter) {})
+	defer h.Stop()
+	h.add("a", 1, 0)
+	h.add("b", 2, 0)
+	assert.Len(t, h.Top(10), 1, "capacity should be raised to 1")
+}
+
+func TestHeavyHittersConns(t *testing.T) {
+	reported := make(chan []HeavyHitter, 10)
+	h := NewHeavyHitters(ByID, 10, 10*time.Millisecond, func(top []HeavyHitter) {
+		reported <- top
+	})
+	defer h.Stop()
+
+	big := Wrap(&addrConn{}, 10*time.Millisecond, nil, WithID("big"), WithHeavyHitters(h))
+	small := Wrap(&addrConn{}, 10*time.Millisecond, nil, WithID("small"), WithHeavyHitters(h))
+	big.Write(make([]byte, 100))
+	small.Write(make([]byte, 10))
+	big.Close()
+	small.Close()
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case top := <-reported:
+			if len(top) == 2 {
+				assert.Equal(t, []HeavyHitter{{Key: "big", Bytes: 100}, {Key: "small", Bytes: 10}}, top)
+				return
+			}
+		case <-deadline:
+			t.Fatal("heavy hitters should have been reported")
+		}
+	}
+}
+
+func TestHeavyHittersReportPanic(t *testing.T) {
+	reports := make(chan bool, 10)
+	h := NewHeavyHitters(ByID, 10, 5*time.Millisecond, func([]HeavyHitter) {
+		reports <- true
+		panic("heavy hitters report")
+	})
+	defer h.Stop()
+	h.add("a", 1, 0)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-reports:
+		case <-time.After(time.Second):
+			t.Fatal("heavy hitters should keep reporting after a panic")
+		}
+	}
+}
+
+func TestHeavyHittersStopTwice(t *testing.T) {
+	h := NewHeavyHitters(ByID, 10, time.Minute, func([]HeavyHitter) {})
+	h.Stop()
+	assert.NotPanics(t, h.Stop)
+}
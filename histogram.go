@@ -0,0 +1,19 @@
+package measured
+
+// SizeHistogram counts Read or Write calls by the number of bytes that they
+// transferred. The buckets are (in order) less than 1 KB, 1 KB up to 8 KB, 8 KB
+// up to 64 KB and 64 KB or more. Calls that transferred nothing aren't counted.
+type SizeHistogram [4]int
+
+func sizeBucket(n int) int {
+	switch {
+	case n < 1024:
+		return 0
+	case n < 8*1024:
+		return 1
+	case n < 64*1024:
+		return 2
+	default:
+		return 3
+	}
+}
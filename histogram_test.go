@@ -0,0 +1,21 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	for _, size := range []int{0, 1, 1023, 1024, 8191, 8192, 65535, 65536, 1000000} {
+		conn.Write(make([]byte, size))
+	}
+	conn.Read(make([]byte, 100))
+
+	stats := conn.Stats()
+	assert.Equal(t, SizeHistogram{2, 2, 2, 2}, stats.SentSizes)
+	assert.Equal(t, SizeHistogram{1, 0, 0, 0}, stats.RecvSizes)
+}
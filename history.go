@@ -0,0 +1,74 @@
+package measured
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Sample records the bytes transferred during one rate interval.
+type Sample struct {
+	// Time is the end of the interval
+	Time time.Time
+	Sent int
+	Recv int
+}
+
+// history is a ring buffer of the most recent samples.
+type history struct {
+	samples  []Sample
+	next     int
+	full     bool
+	lastSent int
+	lastRecv int
+	mx       sync.Mutex
+}
+
+func newHistory(size int) *history {
+	return &history{samples: make([]Sample, size)}
+}
+
+// record adds a sample for the traffic since the previous sample, given the
+// current totals.
+func (h *history) record(ts time.Time, sentTotal int, recvTotal int) {
+	h.mx.Lock()
+	h.samples[h.next] = Sample{ts, sentTotal - h.lastSent, recvTotal - h.lastRecv}
+	h.lastSent, h.lastRecv = sentTotal, recvTotal
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+	h.mx.Unlock()
+}
+
+// get returns the retained samples, oldest first.
+func (h *history) get() []Sample {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if !h.full {
+		return append([]Sample(nil), h.samples[:h.next]...)
+	}
+	result := make([]Sample, 0, len(h.samples))
+	result = append(result, h.samples[h.next:]...)
+	return append(result, h.samples[:h.next]...)
+}
+
+// History returns the per-interval samples of the measured Conn underlying c
+// retained using WithHistory, oldest first. Without WithHistory, or if c isn't
+// (or doesn't wrap) a measured Conn, it returns nil.
+func History(c net.Conn) []Sample {
+	mc, ok := findConn(c)
+	if !ok || mc.history == nil {
+		return nil
+	}
+	return mc.history.get()
+}
+
+func (c *conn) recordHistory() {
+	if c.history == nil {
+		return
+	}
+	sent, _, _, _ := c.sent.get()
+	recv, _, _, _ := c.recv.get()
+	c.history.record(time.Now(), sent, recv)
+}
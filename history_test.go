@@ -0,0 +1,51 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	h := newHistory(3)
+	assert.Empty(t, h.get())
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		h.record(start.Add(time.Duration(i)*time.Second), 10*i*i, i)
+	}
+	samples := h.get()
+	if !assert.Len(t, samples, 3) {
+		return
+	}
+	assert.Equal(t, Sample{start.Add(3 * time.Second), 50, 1}, samples[0])
+	assert.Equal(t, Sample{start.Add(4 * time.Second), 70, 1}, samples[1])
+	assert.Equal(t, Sample{start.Add(5 * time.Second), 90, 1}, samples[2])
+}
+
+func TestConnHistory(t *testing.T) {
+	finished := make(chan []Sample, 1)
+	conn := Wrap(&addrConn{}, 20*time.Millisecond, func(c Conn) {
+		finished <- History(c)
+	}, WithHistory(100))
+	conn.Write(make([]byte, 10))
+	time.Sleep(30 * time.Millisecond)
+	conn.Write(make([]byte, 20))
+	conn.Close()
+
+	samples := <-finished
+	if !assert.True(t, len(samples) >= 2) {
+		return
+	}
+	total := 0
+	for _, sample := range samples {
+		total += sample.Sent
+	}
+	assert.Equal(t, 30, total)
+	assert.Equal(t, 10, samples[0].Sent)
+
+	noHistory := Wrap(&addrConn{}, time.Second, nil)
+	defer noHistory.Close()
+	assert.Nil(t, History(noHistory))
+	assert.Nil(t, History(&addrConn{}), "unmeasured conns have no history")
+}
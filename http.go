@@ -0,0 +1,62 @@
+package measured
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTPStats correlates the HTTP requests served on a conn with its traffic.
+type HTTPStats struct {
+	// Requests is the number of requests served on the conn
+	Requests int
+	// BytesPerRequest is the average number of bytes sent and received per
+	// request
+	BytesPerRequest float64
+}
+
+type httpInstrumentation struct {
+	requests map[Conn]int
+	mx       sync.Mutex
+}
+
+// InstrumentHTTPServer wraps l so that every conn accepted by srv is measured
+// and installs a ConnState hook on srv (chaining any existing one) that counts
+// the requests served on each conn. The returned listener should be passed to
+// srv.Serve or srv.ServeTLS. When a conn finishes, onFinish is called with the
+// conn and its HTTPStats.
+//
+// Requests are counted on transitions to http.StateActive, which is accurate
+// for HTTP/1.x. HTTP/2 conns are counted as a single request because they
+// multiplex requests without changing state.
+func InstrumentHTTPServer(srv *http.Server, l net.Listener, rateInterval time.Duration, onFinish func(Conn, *HTTPStats), options ...Option) Listener {
+	hi := &httpInstrumentation{requests: make(map[Conn]int)}
+	prevConnState := srv.ConnState
+	srv.ConnState = func(nc net.Conn, state http.ConnState) {
+		if state == http.StateActive {
+			if mc, ok := Unwrap(nc); ok {
+				hi.mx.Lock()
+				hi.requests[mc]++
+				hi.mx.Unlock()
+			}
+		}
+		if prevConnState != nil {
+			prevConnState(nc, state)
+		}
+	}
+	return WrapListener(l, rateInterval, func(c Conn) {
+		hi.mx.Lock()
+		requests := hi.requests[c]
+		delete(hi.requests, c)
+		hi.mx.Unlock()
+		httpStats := &HTTPStats{Requests: requests}
+		if requests > 0 {
+			stats := c.Stats()
+			httpStats.BytesPerRequest = float64(stats.SentTotal+stats.RecvTotal) / float64(requests)
+		}
+		if onFinish != nil {
+			onFinish(c, httpStats)
+		}
+	}, options...)
+}
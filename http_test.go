@@ -0,0 +1,55 @@
+package measured
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentHTTPServer(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var states int32
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Write([]byte("hello"))
+		}),
+		ConnState: func(nc net.Conn, state http.ConnState) {
+			atomic.AddInt32(&states, 1)
+		},
+	}
+	finished := make(chan *HTTPStats, 1)
+	ml := InstrumentHTTPServer(srv, l, 10*time.Millisecond, func(c Conn, stats *HTTPStats) {
+		finished <- stats
+	})
+	go srv.Serve(ml)
+	defer srv.Shutdown(context.Background())
+
+	client := &http.Client{Transport: &http.Transport{}}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://" + l.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	client.Transport.(*http.Transport).CloseIdleConnections()
+
+	select {
+	case stats := <-finished:
+		assert.Equal(t, 3, stats.Requests)
+		assert.True(t, stats.BytesPerRequest > 0)
+	case <-time.After(2 * time.Second):
+		t.Fatal("conn should have finished")
+	}
+	assert.True(t, atomic.LoadInt32(&states) > 0, "existing ConnState hook should still be called")
+}
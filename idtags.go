@@ -0,0 +1,23 @@
+package measured
+
+import (
+	"strings"
+)
+
+// SplitID splits a hierarchical ID made up of alternating keys and values
+// separated by sep (e.g. "server/fl-nl-001/client/abc123") into tags (e.g.
+// server=fl-nl-001 and client=abc123). A trailing key without a value is
+// ignored.
+func SplitID(id string, sep string) map[string]string {
+	parts := strings.Split(id, sep)
+	if len(parts) < 2 {
+		return nil
+	}
+	tags := make(map[string]string, len(parts)/2)
+	for i := 0; i+1 < len(parts); i += 2 {
+		if parts[i] != "" {
+			tags[parts[i]] = parts[i+1]
+		}
+	}
+	return tags
+}
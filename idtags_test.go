@@ -0,0 +1,26 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitID(t *testing.T) {
+	assert.Equal(t, map[string]string{"server": "fl-nl-001", "client": "abc123"}, SplitID("server/fl-nl-001/client/abc123", "/"))
+	assert.Equal(t, map[string]string{"server": "fl-nl-001"}, SplitID("server:fl-nl-001:client", ":"))
+	assert.Nil(t, SplitID("12", "/"))
+}
+
+func TestIDTags(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil,
+		WithID("server/fl-nl-001/client/abc123"),
+		WithIDTags("/"),
+		WithTags(map[string]string{"client": "explicit"}))
+	defer conn.Close()
+
+	stats := conn.Stats()
+	assert.Equal(t, "server/fl-nl-001/client/abc123", stats.ID)
+	assert.Equal(t, map[string]string{"server": "fl-nl-001", "client": "explicit"}, stats.Tags)
+}
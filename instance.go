@@ -0,0 +1,75 @@
+package measured
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Instance is an isolated set of measured conns with its own registry and
+// default options, so that a process hosting several frontends can keep their
+// measurements separate. The package-level functions like Wrap, Aggregate and
+// Handler operate on a default instance. PublishExpvars, StartSnapshots and
+// SLOChecker only cover the default instance.
+type Instance struct {
+	reg     *registry
+	options []Option
+}
+
+// NewInstance creates an Instance whose conns get the given options in
+// addition to the ones passed when wrapping them.
+func NewInstance(options ...Option) *Instance {
+	return &Instance{reg: newRegistry(), options: options}
+}
+
+// WithInstance registers the conn with the given Instance rather than the
+// default one and applies the Instance's options at this position.
+func WithInstance(i *Instance) Option {
+	return func(o *opts) {
+		o.instance = i
+		for _, option := range i.options {
+			option(o)
+		}
+	}
+}
+
+// with prepends WithInstance to options, so that explicit options take
+// precedence over the Instance's.
+func (i *Instance) with(options []Option) []Option {
+	return append([]Option{WithInstance(i)}, options...)
+}
+
+// Wrap is like the package-level Wrap, but registers the conn with this
+// Instance.
+func (i *Instance) Wrap(wrapped net.Conn, rateInterval time.Duration, onFinish func(Conn), options ...Option) Conn {
+	return Wrap(wrapped, rateInterval, onFinish, i.with(options)...)
+}
+
+// WrapListener is like the package-level WrapListener, but registers accepted
+// conns with this Instance.
+func (i *Instance) WrapListener(l net.Listener, rateInterval time.Duration, onFinish func(Conn), options ...Option) Listener {
+	return WrapListener(l, rateInterval, onFinish, i.with(options)...)
+}
+
+// Aggregate returns statistics aggregated across the conns of this Instance.
+func (i *Instance) Aggregate() *AggregateStats {
+	return i.reg.aggregate()
+}
+
+// AggregateDials returns statistics about dials made through DialerMiddleware
+// with this Instance.
+func (i *Instance) AggregateDials() *AggregateDialStats {
+	return i.reg.dials.aggregate()
+}
+
+// Handler is like the package-level Handler, but serves this Instance's conns.
+func (i *Instance) Handler() http.Handler {
+	return handlerFor(i.reg)
+}
+
+// DumpConnections is like the package-level DumpConnections, but lists this
+// Instance's conns.
+func (i *Instance) DumpConnections(w io.Writer) error {
+	return dumpConnections(w, i.reg)
+}
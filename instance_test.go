@@ -0,0 +1,41 @@
+package measured
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstance(t *testing.T) {
+	defaultBefore := Aggregate()
+	a := NewInstance(WithID("a"))
+	b := NewInstance()
+
+	conn := a.Wrap(&addrConn{remote: &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 7654}}, time.Second, nil)
+	conn.Write([]byte("12345"))
+	assert.Equal(t, "a", ID(conn), "instance options should apply")
+
+	assert.Equal(t, 1, a.Aggregate().OpenConns)
+	assert.Equal(t, 5, a.Aggregate().SentTotal)
+	assert.Equal(t, 0, b.Aggregate().OpenConns, "instances should be isolated")
+	assert.Equal(t, defaultBefore.SentTotal, Aggregate().SentTotal, "default instance should be unaffected")
+
+	var buf bytes.Buffer
+	assert.NoError(t, a.DumpConnections(&buf))
+	assert.Contains(t, buf.String(), "1.2.3.4:7654")
+	buf.Reset()
+	assert.NoError(t, b.DumpConnections(&buf))
+	assert.NotContains(t, buf.String(), "1.2.3.4:7654")
+
+	override := a.Wrap(&addrConn{}, time.Second, nil, WithID("override"))
+	assert.Equal(t, "override", ID(override), "explicit options should take precedence")
+	override.Close()
+
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, a.Aggregate().OpenConns)
+	assert.Equal(t, 5, a.Aggregate().SentTotal, "finished conns should still count towards instance totals")
+}
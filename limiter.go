@@ -0,0 +1,44 @@
+package measured
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Limiter is implemented by rate limiters like golang.org/x/time/rate.Limiter.
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// measuredLimiter records the time spent waiting on a Limiter as throttled
+// time of a conn.
+type measuredLimiter struct {
+	Limiter
+	c *conn
+}
+
+// MeasureLimiter returns a Limiter that delegates to l and records the time
+// spent in WaitN as Stats.ThrottledTime of the measured Conn underlying c, so
+// that reports can separate intentional throttling from network slowness. If c
+// isn't (or doesn't wrap) a measured Conn, l is returned as is.
+func MeasureLimiter(c net.Conn, l Limiter) Limiter {
+	mc, ok := findConn(c)
+	if !ok {
+		return l
+	}
+	return &measuredLimiter{Limiter: l, c: mc}
+}
+
+func (l *measuredLimiter) WaitN(ctx context.Context, n int) error {
+	start := now()
+	err := l.Limiter.WaitN(ctx, n)
+	l.c.addThrottled(now().Sub(start))
+	return err
+}
+
+// addThrottled records time that the conn spent intentionally throttled.
+func (c *conn) addThrottled(d time.Duration) {
+	atomic.AddInt64(&c.throttled, int64(d))
+}
@@ -0,0 +1,39 @@
+package measured
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sleepLimiter is a Limiter that waits 1 ms per byte.
+type sleepLimiter struct{}
+
+func (l sleepLimiter) WaitN(ctx context.Context, n int) error {
+	time.Sleep(time.Duration(n) * time.Millisecond)
+	return nil
+}
+
+func TestMeasureLimiter(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	l := MeasureLimiter(conn, sleepLimiter{})
+	assert.NoError(t, l.WaitN(context.Background(), 10))
+	assert.NoError(t, l.WaitN(context.Background(), 5))
+	throttled := conn.Stats().ThrottledTime
+	assert.True(t, throttled >= 15*time.Millisecond, "throttled %v", throttled)
+	assert.True(t, throttled < time.Second, "throttled %v", throttled)
+
+	assert.Equal(t, sleepLimiter{}, MeasureLimiter(&addrConn{}, sleepLimiter{}), "unmeasured conns should get the limiter itself")
+}
+
+func TestQuotaThrottledTime(t *testing.T) {
+	q := NewQuotas(QuotaConfig{Limit: 1, Policy: QuotaThrottle, ThrottleRate: 1000})
+	conn := Wrap(&addrConn{}, time.Second, nil, WithQuotas(q))
+	defer conn.Close()
+	conn.Write(make([]byte, 1))
+	conn.Write(make([]byte, 20))
+	assert.Equal(t, 20*time.Millisecond, conn.Stats().ThrottledTime)
+}
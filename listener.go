@@ -1,26 +1,173 @@
 package measured
 
 import (
+	"context"
 	"net"
+	"sync"
 	"time"
 )
 
+// OverflowPolicy determines what a measured listener does with accepted conns
+// once it's tracking the maximum number of conns.
+type OverflowPolicy int
+
+const (
+	// PassThrough returns excess conns from Accept without measuring them
+	PassThrough OverflowPolicy = iota
+	// Reject closes excess conns without returning them from Accept
+	Reject
+)
+
+// Listener is a net.Listener that measures accepted connections.
+type Listener interface {
+	net.Listener
+
+	// CloseAndWait closes the listener and waits until all conns accepted from
+	// it have finished or ctx is done, whichever comes first. It returns
+	// aggregate stats for all measured conns accepted from the listener, with
+	// OpenConns indicating how many were still open when it returned. If ctx
+	// finished first, the error is ctx.Err().
+	CloseAndWait(ctx context.Context) (*AggregateStats, error)
+}
+
 type listener struct {
 	net.Listener
 	rateInterval time.Duration
 	onFinish     func(Conn)
+	options      []Option
+	maxConns     int
+	overflow     OverflowPolicy
+	proxy        bool
+	proxyTimeout time.Duration
+	proxyOnce    sync.Once
+	accepted     chan acceptResult
+	acceptErr    error
+	acceptDone   chan interface{}
+	headerSlots  chan struct{}
+	live         map[Conn]bool
+	tracked      int
+	finishedSent int
+	finishedRecv int
+	closing      bool
+	drainedCh    chan interface{}
+	drainOnce    sync.Once
+	closedCh     chan interface{}
+	closeOnce    sync.Once
+	mx           sync.Mutex
 }
 
 // WrapListener wraps an existing listener with one that will measure accepted
-// connections.
-func WrapListener(l net.Listener, rateInterval time.Duration, onFinish func(Conn)) net.Listener {
-	return &listener{l, rateInterval, onFinish}
+// connections. The given options are applied to every accepted connection.
+func WrapListener(l net.Listener, rateInterval time.Duration, onFinish func(Conn), options ...Option) Listener {
+	o := buildOpts(options)
+	return &listener{
+		Listener:     l,
+		rateInterval: rateInterval,
+		onFinish:     onFinish,
+		options:      options,
+		maxConns:     o.maxConns,
+		overflow:     o.overflow,
+		proxy:        o.proxyProtocol,
+		proxyTimeout: o.proxyTimeout,
+		accepted:     make(chan acceptResult),
+		acceptDone:   make(chan interface{}),
+		headerSlots:  make(chan struct{}, maxPendingProxyHeaders),
+		live:         make(map[Conn]bool),
+		drainedCh:    make(chan interface{}),
+		closedCh:     make(chan interface{}),
+	}
 }
 
 func (l *listener) Accept() (net.Conn, error) {
-	conn, err := l.Listener.Accept()
-	if err == nil {
-		conn = Wrap(conn, l.rateInterval, l.onFinish)
+	for {
+		var conn net.Conn
+		var err error
+		if l.proxy {
+			conn, err = l.acceptProxied()
+		} else {
+			conn, err = l.Listener.Accept()
+		}
+		if err != nil {
+			return conn, err
+		}
+		l.mx.Lock()
+		if l.maxConns > 0 && l.tracked >= l.maxConns {
+			l.mx.Unlock()
+			if l.overflow == Reject {
+				conn.Close()
+				continue
+			}
+			return conn, nil
+		}
+		l.tracked++
+		l.mx.Unlock()
+
+		mc := Wrap(conn, l.rateInterval, l.finished, l.options...)
+		l.mx.Lock()
+		l.live[mc] = true
+		l.mx.Unlock()
+		return mc, nil
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closedCh)
+	})
+	return l.Listener.Close()
+}
+
+func (l *listener) finished(conn Conn) {
+	stats := conn.Stats()
+	l.mx.Lock()
+	delete(l.live, conn)
+	l.tracked--
+	l.finishedSent += stats.SentTotal
+	l.finishedRecv += stats.RecvTotal
+	drained := l.closing && l.tracked == 0
+	l.mx.Unlock()
+	if drained {
+		l.drain()
+	}
+	if l.onFinish != nil {
+		l.onFinish(conn)
+	}
+}
+
+func (l *listener) drain() {
+	l.drainOnce.Do(func() {
+		close(l.drainedCh)
+	})
+}
+
+func (l *listener) CloseAndWait(ctx context.Context) (*AggregateStats, error) {
+	l.Close()
+	l.mx.Lock()
+	l.closing = true
+	drained := l.tracked == 0
+	l.mx.Unlock()
+	if drained {
+		l.drain()
+	}
+
+	var err error
+	select {
+	case <-l.drainedCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	stats := &AggregateStats{
+		OpenConns: len(l.live),
+		SentTotal: l.finishedSent,
+		RecvTotal: l.finishedRecv,
+	}
+	for conn := range l.live {
+		connStats := conn.Stats()
+		stats.SentTotal += connStats.SentTotal
+		stats.RecvTotal += connStats.RecvTotal
 	}
-	return conn, err
+	return stats, err
 }
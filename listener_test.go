@@ -1,6 +1,8 @@
 package measured
 
 import (
+	"context"
+	"io"
 	"net"
 	"sync"
 	"testing"
@@ -42,11 +44,11 @@ func TestMeasuredListener(t *testing.T) {
 
 	go func() {
 		_conn, err := ml.Accept()
-		if err != nil {
-			t.Fatal(err)
+		if !assert.NoError(t, err) {
+			return
 		}
 		defer _conn.Close()
-		conn := &slowConn{_conn}
+		conn := slowConn(_conn)
 		n, err := conn.Write([]byte("12345678"))
 		if !assert.NoError(t, err) {
 			return
@@ -70,3 +72,196 @@ func TestMeasuredListener(t *testing.T) {
 	conn.Close()
 	wg.Wait()
 }
+
+func TestMaxConnsPassThrough(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	finished := make(chan interface{}, 10)
+	ml := WrapListener(l, 10*time.Millisecond, func(conn Conn) {
+		finished <- nil
+	}, WithMaxConns(1, PassThrough))
+	defer ml.Close()
+
+	for i := 0; i < 3; i++ {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer client.Close()
+	}
+
+	first, err := ml.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, measured := first.(Conn)
+	assert.True(t, measured, "first conn should be measured")
+
+	second, err := ml.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, measured = second.(Conn)
+	assert.False(t, measured, "conns over the limit should pass through unmeasured")
+	second.Close()
+
+	// Free up a slot, after which conns are measured again
+	first.Close()
+	<-finished
+	third, err := ml.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, measured = third.(Conn)
+	assert.True(t, measured, "conns should be measured once below the limit")
+	third.Close()
+}
+
+func TestMaxConnsReject(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	finished := make(chan interface{}, 10)
+	ml := WrapListener(l, 10*time.Millisecond, func(conn Conn) {
+		finished <- nil
+	}, WithMaxConns(1, Reject))
+	defer ml.Close()
+
+	dial := func() net.Conn {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return client
+	}
+
+	client1 := dial()
+	defer client1.Close()
+	first, err := ml.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := ml.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	client2 := dial()
+	defer client2.Close()
+	client2.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = client2.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err, "conns over the limit should be rejected")
+
+	// Free up a slot, after which conns are accepted again
+	first.Close()
+	<-finished
+	client3 := dial()
+	defer client3.Close()
+	select {
+	case conn := <-accepted:
+		_, measured := conn.(Conn)
+		assert.True(t, measured)
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("conn should have been accepted once below the limit")
+	}
+}
+
+func TestListenerIDFunc(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ml := WrapListener(l, time.Second, nil, WithIDFunc(func(conn net.Conn) string {
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		return "client-" + host
+	}))
+	defer ml.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	conn, err := ml.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(t, "client-127.0.0.1", ID(conn))
+	assert.Equal(t, "client-127.0.0.1", conn.(Conn).Stats().ID)
+}
+
+func TestCloseAndWait(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ml := WrapListener(l, 10*time.Millisecond, nil)
+
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer client.Close()
+		conn, err := ml.Accept()
+		if !assert.NoError(t, err) {
+			return
+		}
+		conn.Write([]byte("12345"))
+		conns = append(conns, conn)
+	}
+
+	// Times out while conns are still open
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	stats, err := ml.CloseAndWait(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 2, stats.OpenConns)
+	assert.Equal(t, 10, stats.SentTotal)
+
+	_, err = ml.Accept()
+	assert.Error(t, err, "listener should be closed")
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	stats, err = ml.CloseAndWait(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.OpenConns)
+	assert.Equal(t, 10, stats.SentTotal)
+}
+
+func TestListenerLabels(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ml := WrapListener(l, time.Second, nil, WithLabels(map[string]string{"port": "443"}), WithLabels(map[string]string{"protocol": "obfs4"}))
+	defer ml.Close()
+	go func() {
+		conn, err := net.Dial("tcp", ml.Addr().String())
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	conn, err := ml.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(t, map[string]string{"port": "443", "protocol": "obfs4"}, conn.(Conn).Stats().Tags)
+}
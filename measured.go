@@ -1,10 +1,14 @@
 package measured
 
 import (
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/getlantern/mtime"
@@ -12,6 +16,8 @@ import (
 
 // Stats provides statistics about total transfer and rates, all in bytes.
 type Stats struct {
+	// ID is the ID of the conn
+	ID        string
 	SentTotal int
 	SentMin   float64
 	SentMax   float64
@@ -20,13 +26,177 @@ type Stats struct {
 	RecvMin   float64
 	RecvMax   float64
 	RecvAvg   float64
+	// SentEWMA and RecvEWMA are exponentially weighted moving average rates.
+	// They are only populated if the conn was wrapped WithEWMA.
+	SentEWMA float64
+	RecvEWMA float64
+	// SentWire and RecvWire count the bytes that actually went over the wire,
+	// which can differ from SentTotal and RecvTotal when the measured conn wraps
+	// a TLS or buffered layer. They are only populated if the underlying raw
+	// conn was wrapped with WrapWire.
+	SentWire int
+	RecvWire int
+	// RTT, RTTVar, Retransmits and CongestionWindow (in segments) are sampled
+	// from the kernel's TCP_INFO. They are only populated if the conn was
+	// wrapped WithTCPInfo.
+	RTT              time.Duration
+	RTTVar           time.Duration
+	Retransmits      int
+	CongestionWindow int
+	// TLS indicates that the conn is (or wraps) a TLS connection whose handshake
+	// has completed, in which case TLSResumed indicates whether the handshake
+	// resumed a previous session.
+	TLS        bool
+	TLSResumed bool
+	// Tags contains the metadata attached to the conn using WithTags and
+	// SetTag.
+	Tags map[string]string
+	// Phases lists the protocol phases recorded using MarkPhase, in order
+	Phases []Phase
+	// FirstReadError and FirstWriteError are the first unexpected errors
+	// encountered while reading and writing respectively. They're omitted from
+	// JSON because errors don't serialize meaningfully.
+	FirstReadError  error `json:"-"`
+	FirstWriteError error `json:"-"`
+	// ClosedBy indicates which side initiated closing the conn
+	ClosedBy ClosedBy
+	// Termination classifies how the conn was terminated
+	Termination Termination
+	// SentStddev and RecvStddev are the standard deviations of the rates
+	// calculated at each rate interval during which the conn was active.
+	SentStddev float64
+	RecvStddev float64
+	// ReadJitter and WriteJitter are the inter-arrival jitter of Reads and
+	// Writes that transferred data, calculated as in RFC 3550 as the smoothed
+	// mean deviation of the gaps between them.
+	ReadJitter  time.Duration
+	WriteJitter time.Duration
+	// AvgReadLatency, MaxReadLatency, AvgWriteLatency and MaxWriteLatency
+	// describe how long individual Read and Write calls took, measured using a
+	// monotonic clock with sub-millisecond resolution.
+	AvgReadLatency  time.Duration
+	MaxReadLatency  time.Duration
+	AvgWriteLatency time.Duration
+	MaxWriteLatency time.Duration
+	// SentSizes and RecvSizes are histograms of how many bytes individual
+	// Write and Read calls transferred.
+	SentSizes SizeHistogram
+	RecvSizes SizeHistogram
+	// Ops and OpErrors count the operations begun with BeginOp that have
+	// ended, and those that ended with an error. AvgOpLatency, MaxOpLatency and
+	// OpLatencies describe how long they took.
+	Ops          int
+	OpErrors     int
+	AvgOpLatency time.Duration
+	MaxOpLatency time.Duration
+	OpLatencies  LatencyHistogram
+	// ReadOps and WriteOps count the calls to Read and Write, including ones
+	// that failed or transferred nothing.
+	ReadOps  int
+	WriteOps int
+	// ProbeRTT is the round-trip time measured by the most recent successful
+	// probe, and Probes and ProbeErrors count all probes and the failed ones.
+	// They are only populated if the conn was wrapped WithIdleProbe.
+	ProbeRTT    time.Duration
+	Probes      int
+	ProbeErrors int
+	// ThrottledTime is the time the conn spent intentionally throttled, either
+	// waiting on a Limiter returned by MeasureLimiter or by Quotas.
+	ThrottledTime time.Duration
+	// SentOverhead and RecvOverhead are the bytes marked as protocol overhead
+	// using AddOverhead. PayloadEfficiency is the ratio of payload (total minus
+	// overhead) to total bytes, or 0 if nothing has been transferred.
+	SentOverhead      int
+	RecvOverhead      int
+	PayloadEfficiency float64
+	// ReadTimeouts and WriteTimeouts count the Reads and Writes that failed
+	// because a deadline expired. Such failures aren't considered unexpected
+	// errors.
+	ReadTimeouts  int
+	WriteTimeouts int
+	// DeadlineSets counts the calls to SetDeadline, SetReadDeadline and
+	// SetWriteDeadline.
+	DeadlineSets int
+	// Leaked indicates that tracking stopped because the conn was inactive for
+	// longer than the timeout configured using WithLeakTimeout without being
+	// closed.
+	Leaked bool
+	// StartTime is the wall clock time at which the conn was wrapped by
+	// measured.
+	StartTime time.Time
+	// Dial describes how the conn was dialed. It's only populated for conns
+	// dialed through DialerMiddleware.
+	Dial *DialStats
 	// Duration indicates how long it has been since the connection was opened
 	// (more precisely, how long it's been since it was wrapped by measured).
+	// It's measured using a monotonic clock, so it's unaffected by wall clock
+	// adjustments.
 	Duration time.Duration
 }
 
+// ClosedBy indicates which side initiated closing a conn.
+type ClosedBy int
+
+const (
+	// NotClosed means that the conn hasn't been closed yet
+	NotClosed ClosedBy = iota
+	// ClosedLocally means that Close was called before the remote end closed
+	// the conn
+	ClosedLocally
+	// ClosedRemotely means that the remote end closed (or reset) the conn
+	// before Close was called
+	ClosedRemotely
+)
+
+func (cb ClosedBy) String() string {
+	switch cb {
+	case ClosedLocally:
+		return "local"
+	case ClosedRemotely:
+		return "remote"
+	default:
+		return "not closed"
+	}
+}
+
+// Termination classifies how a conn was terminated.
+type Termination int
+
+const (
+	// NotTerminated means that the conn is still open
+	NotTerminated Termination = iota
+	// TerminatedEOF means that the remote end closed the conn cleanly
+	TerminatedEOF
+	// TerminatedReset means that the remote end reset the conn
+	TerminatedReset
+	// TerminatedTimeout means that the conn was closed locally after the most
+	// recent Read or Write timed out
+	TerminatedTimeout
+	// TerminatedLocally means that the conn was closed locally
+	TerminatedLocally
+)
+
+func (t Termination) String() string {
+	switch t {
+	case TerminatedEOF:
+		return "eof"
+	case TerminatedReset:
+		return "reset"
+	case TerminatedTimeout:
+		return "timeout"
+	case TerminatedLocally:
+		return "local"
+	default:
+		return "not terminated"
+	}
+}
+
 // Conn is a wrapped net.Conn that exposes statistics about transfer data and
 // the first error encountered during processing.
+//
+// Conn has gained methods over time (see the README), which breaks
+// implementations outside of this package. Such implementations, e.g. mocks,
+// should embed a Conn so that they keep compiling when methods are added.
 type Conn interface {
 	net.Conn
 
@@ -39,43 +209,352 @@ type Conn interface {
 
 	// Wrapped() exposes the wrapped net.Conn
 	Wrapped() net.Conn
+
+	// Unwrap and NetConn are equivalent to Wrapped and exist so that generic
+	// unwrapping utilities following the Unwrap() and tls.Conn NetConn()
+	// conventions find the wrapped net.Conn.
+	Unwrap() net.Conn
+	NetConn() net.Conn
 }
 
 // conn wraps a net.Conn and tracks statistics on data transfer, throughput
 // and success of connection.
 type conn struct {
+	// readTimeouts, writeTimeouts, deadlineSets and throttled are accessed atomically and
+	// must stay 64-bit aligned.
+	readTimeouts  int64
+	writeTimeouts int64
+	deadlineSets  int64
+	throttled     int64
+	// sentCount and recvCount are only used in counters-only mode
+	sentCount int64
+	recvCount int64
+	// sentOverhead and recvOverhead are added to by AddOverhead
+	sentOverhead int64
+	recvOverhead int64
+	// inFlight counts the Reads and Writes currently in progress
+	inFlight int64
 	net.Conn
-	startTime time.Time
-	onFinish  func(Conn)
-	sent      rater
-	recv      rater
-	firstErr  error
-	closeOnce sync.Once
-	closedCh  chan interface{}
-	errMx     sync.RWMutex
+	id             string
+	explicitID     bool
+	reg            *registry
+	dial           *DialStats
+	countersOnly   bool
+	startTime      time.Time
+	start          mtime.Instant
+	onFinish       func(Conn)
+	moreOnFinish   []func(Conn)
+	callbackPool   *CallbackPool
+	finished       bool
+	sent           rater
+	recv           rater
+	wire           *wireConn
+	sc             syscall.Conn
+	tcpInfo        *tcpInfo
+	tc             tlsConn
+	tlsState       *tls.ConnectionState
+	tags           map[string]string
+	idTags         map[string]string
+	labels         map[string]string
+	familyTags     map[string]string
+	values         map[interface{}]interface{}
+	guard          *CardinalityGuard
+	marks          []mark
+	ops            opStats
+	aggs           []*aggregation
+	enrichers      []Enricher
+	th             *thresholds
+	quota          *quota
+	mirror         *mirror
+	capture        *capture
+	window         *window
+	history        *history
+	reportInterval time.Duration
+	sentInterval   time.Duration
+	recvInterval   time.Duration
+	report         func(Conn, *Stats)
+	lastReport     *Stats
+	errFilter      func(error) bool
+	leakAfter      time.Duration
+	leaked         bool
+	closeLeaked    bool
+	prober         *prober
+	firstErr       error
+	readErr        error
+	writeErr       error
+	lastErr        error
+	closedBy       ClosedBy
+	termination    Termination
+	timedOut       int32
+	closeOnce      sync.Once
+	closedCh       chan interface{}
+	errMx          sync.RWMutex
+	sampleMx       sync.RWMutex
+	metaMx         sync.RWMutex
+}
+
+var lastID uint64
+
+const (
+	// DefaultRateInterval is the rate interval used when Wrap is given a zero
+	// or negative one.
+	DefaultRateInterval = time.Second
+	// MinRateInterval is the smallest supported rate interval. Wrap raises
+	// shorter positive intervals to it, since recalculating rates more often
+	// costs a lot of CPU without making them meaningfully more accurate.
+	MinRateInterval = time.Millisecond
+	// DefaultReportInterval is the interval used by NewAggregator,
+	// NewHeavyHitters, NewSLOChecker and StartSnapshots when given a zero or
+	// negative one.
+	DefaultReportInterval = time.Minute
+)
+
+// ErrInvalidRateInterval is returned by WrapStrict for rate intervals shorter
+// than MinRateInterval.
+var ErrInvalidRateInterval = errors.New("measured: rate interval must be at least " + MinRateInterval.String())
+
+// WrapStrict is like Wrap, but instead of substituting a valid rate interval
+// it returns ErrInvalidRateInterval if rateInterval is shorter than
+// MinRateInterval. Intervals set WithRateIntervals are validated the same way.
+func WrapStrict(wrapped net.Conn, rateInterval time.Duration, onFinish func(Conn), options ...Option) (Conn, error) {
+	if rateInterval < MinRateInterval {
+		return nil, ErrInvalidRateInterval
+	}
+	o := buildOpts(options)
+	for _, interval := range []time.Duration{o.sentInterval, o.recvInterval} {
+		if interval != 0 && interval < MinRateInterval {
+			return nil, ErrInvalidRateInterval
+		}
+	}
+	return Wrap(wrapped, rateInterval, onFinish, options...), nil
+}
+
+// validRateInterval substitutes DefaultRateInterval for zero or negative
+// intervals and raises positive ones to MinRateInterval.
+func validRateInterval(d time.Duration) time.Duration {
+	switch {
+	case d <= 0:
+		return DefaultRateInterval
+	case d < MinRateInterval:
+		return MinRateInterval
+	default:
+		return d
+	}
+}
+
+// validReportInterval substitutes DefaultReportInterval for zero or negative
+// intervals.
+func validReportInterval(d time.Duration) time.Duration {
+	if d <= 0 {
+		return DefaultReportInterval
+	}
+	return d
 }
 
 // Wrap wraps a connection into a measured Conn that recalculates rates at the
-// given interval.
-func Wrap(wrapped net.Conn, rateInterval time.Duration, onFinish func(Conn)) Conn {
+// given interval. A zero or negative interval is replaced with
+// DefaultRateInterval and intervals shorter than MinRateInterval are raised to
+// it, use WrapStrict to reject them instead.
+//
+// onFinish is called exactly once after the conn is closed (or leaked), no
+// matter how many times Close is called or whether the remote end closed it
+// first. Panics in onFinish are recovered and logged.
+//
+// Wrapping a conn that is itself a measured Conn doesn't add another
+// measurement layer. Instead, the existing Conn is returned, with onFinish
+// registered as an additional callback and any WithTags merged into its tags;
+// other options are ignored. Measured conns deeper in the wrap chain (for
+// example underneath a TLS layer) are measured independently, use Unwrap to
+// find them.
+func Wrap(wrapped net.Conn, rateInterval time.Duration, onFinish func(Conn), options ...Option) Conn {
+	o := buildOpts(options)
+	if existing, ok := wrapped.(*conn); ok {
+		return existing.rewrap(onFinish, o)
+	}
+	id := o.id
+	if id == "" && o.idFunc != nil {
+		id = o.idFunc(wrapped)
+	}
+	explicitID := id != ""
+	if !explicitID {
+		id = strconv.FormatUint(atomic.AddUint64(&lastID, 1), 10)
+	}
 	c := &conn{
-		Conn:      wrapped,
-		startTime: time.Now(),
-		onFinish:  onFinish,
-		closedCh:  make(chan interface{}),
+		Conn:         wrapped,
+		id:           id,
+		explicitID:   explicitID,
+		reg:          reg,
+		startTime:    time.Now(),
+		start:        now(),
+		onFinish:     onFinish,
+		wire:         findWire(wrapped),
+		tc:           findTLSConn(wrapped),
+		tags:         o.tags,
+		labels:       o.labels,
+		enrichers:    o.enrichers,
+		errFilter:    o.errorFilter,
+		leakAfter:    o.leakTimeout,
+		closeLeaked:  o.leakClose,
+		callbackPool: o.callbackPool,
+		closedCh:     make(chan interface{}),
+	}
+	if o.instance != nil {
+		c.reg = o.instance.reg
+	}
+	c.dial = o.dialStats
+	c.guard = o.cardinalityGuard
+	if o.idSeparator != "" {
+		c.idTags = SplitID(id, o.idSeparator)
+	}
+	if o.familyTag {
+		if family := connFamily(wrapped); family != "" {
+			c.familyTags = map[string]string{FamilyTag: family}
+		}
+	}
+	if o.countersOnly {
+		c.countersOnly = true
+		return c
+	}
+	if o.mirrorSink != nil && o.mirrorLimit > 0 {
+		c.mirror = newMirror(o.mirrorLimit, o.mirrorSink)
+	}
+	if o.captureFn != nil && o.captureSize > 0 {
+		c.capture = newCapture(o.captureSize, o.captureFn)
+	}
+	if o.windowSize > 0 && o.windowResolution > 0 {
+		c.window = newWindow(o.windowSize, o.windowResolution)
+	}
+	if o.report != nil {
+		c.reportInterval = o.reportInterval
+		c.report = o.report
+	}
+	if o.historySize > 0 {
+		c.history = newHistory(o.historySize)
+	}
+	if o.probe != nil && o.probeInterval > 0 {
+		c.prober = &prober{interval: o.probeInterval, probe: o.probe}
+	}
+	if o.sentInterval != 0 {
+		c.sentInterval = validRateInterval(o.sentInterval)
+	}
+	if o.recvInterval != 0 {
+		c.recvInterval = validRateInterval(o.recvInterval)
 	}
-	go c.track(rateInterval)
+	c.sent.halfLife = o.ewmaHalfLife
+	c.recv.halfLife = o.ewmaHalfLife
+	if o.tcpInfo {
+		c.sc = findSyscallConn(wrapped)
+	}
+	if len(o.thresholds) > 0 {
+		c.th = newThresholds(o.thresholds)
+	}
+	for _, a := range o.aggregators {
+		c.aggs = append(c.aggs, &aggregation{aggregator: a})
+		if h, ok := a.(*HeavyHitters); ok {
+			c.reg.addHeavyHitters(h)
+		}
+	}
+	if o.quotas != nil {
+		c.quota = &quota{quotas: o.quotas, bucket: o.quotas.cfg.Bucket(c)}
+		o.quotas.attach(c.quota.bucket)
+	}
+	c.reg.add(c)
+	go c.track(validRateInterval(rateInterval))
 	return c
 }
 
+// Stats is safe to call concurrently with Read and Write. The transfer totals,
+// rates, latencies and size histograms of both directions are taken from a
+// single coherent snapshot.
 func (c *conn) Stats() *Stats {
-	stats := &Stats{}
-	stats.SentTotal, stats.SentMin, stats.SentMax, stats.SentAvg = c.sent.get()
-	stats.RecvTotal, stats.RecvMin, stats.RecvMax, stats.RecvAvg = c.recv.get()
-	stats.Duration = time.Since(c.startTime)
+	stats := &Stats{ID: c.id}
+	sent, recv := snapshotBoth(&c.sent, &c.recv)
+	stats.SentTotal, stats.SentMin, stats.SentMax, stats.SentAvg = sent.total, sent.min, sent.max, sent.average
+	stats.RecvTotal, stats.RecvMin, stats.RecvMax, stats.RecvAvg = recv.total, recv.min, recv.max, recv.average
+	stats.SentEWMA = sent.ewma
+	stats.RecvEWMA = recv.ewma
+	stats.SentSizes = sent.sizes
+	stats.RecvSizes = recv.sizes
+	stats.AvgWriteLatency, stats.MaxWriteLatency = sent.avgLatency, sent.maxLatency
+	stats.AvgReadLatency, stats.MaxReadLatency = recv.avgLatency, recv.maxLatency
+	stats.WriteOps, stats.ReadOps = sent.ops, recv.ops
+	if c.countersOnly {
+		stats.SentTotal = int(atomic.LoadInt64(&c.sentCount))
+		stats.RecvTotal = int(atomic.LoadInt64(&c.recvCount))
+	}
+	stats.SentStddev, stats.RecvStddev = sent.stddev, recv.stddev
+	stats.WriteJitter, stats.ReadJitter = sent.jitter, recv.jitter
+	c.ops.fill(stats)
+	if c.prober != nil {
+		c.prober.fill(stats)
+	}
+	if c.wire != nil {
+		stats.SentWire, stats.RecvWire = c.wire.get()
+	}
+	c.sampleMx.RLock()
+	if info := c.tcpInfo; info != nil {
+		stats.RTT = info.rtt
+		stats.RTTVar = info.rttVar
+		stats.Retransmits = info.retransmits
+		stats.CongestionWindow = info.cwnd
+	}
+	if state := c.tlsState; state != nil {
+		stats.TLS = true
+		stats.TLSResumed = state.DidResume
+	}
+	c.sampleMx.RUnlock()
+	c.metaMx.RLock()
+	if len(c.tags) > 0 {
+		stats.Tags = make(map[string]string, len(c.tags))
+		for k, v := range c.tags {
+			stats.Tags[k] = v
+		}
+	}
+	stats.Phases = c.phases()
+	c.metaMx.RUnlock()
+	// ID tags, labels and the family don't overwrite tags set on the conn
+	addMissingTags(stats, c.idTags)
+	addMissingTags(stats, c.labels)
+	addMissingTags(stats, c.familyTags)
+	if c.guard != nil {
+		stats.Tags = c.guard.Guard(stats.Tags)
+	}
+	c.errMx.RLock()
+	stats.FirstReadError = c.readErr
+	stats.FirstWriteError = c.writeErr
+	stats.ClosedBy = c.closedBy
+	stats.Termination = c.termination
+	stats.Leaked = c.leaked
+	c.errMx.RUnlock()
+	stats.ReadTimeouts = int(atomic.LoadInt64(&c.readTimeouts))
+	stats.WriteTimeouts = int(atomic.LoadInt64(&c.writeTimeouts))
+	stats.DeadlineSets = int(atomic.LoadInt64(&c.deadlineSets))
+	stats.ThrottledTime = time.Duration(atomic.LoadInt64(&c.throttled))
+	stats.SentOverhead = int(atomic.LoadInt64(&c.sentOverhead))
+	stats.RecvOverhead = int(atomic.LoadInt64(&c.recvOverhead))
+	stats.PayloadEfficiency = payloadEfficiency(stats.SentTotal, stats.RecvTotal, stats.SentOverhead, stats.RecvOverhead)
+	stats.StartTime = c.startTime
+	stats.Dial = c.dial
+	stats.Duration = c.age()
 	return stats
 }
 
+// addMissingTags adds the given tags to stats, except for keys that are
+// already set.
+func addMissingTags(stats *Stats, tags map[string]string) {
+	if len(tags) == 0 {
+		return
+	}
+	if stats.Tags == nil {
+		stats.Tags = make(map[string]string, len(tags))
+	}
+	for k, v := range tags {
+		if _, found := stats.Tags[k]; !found {
+			stats.Tags[k] = v
+		}
+	}
+}
+
 func (c *conn) FirstError() error {
 	c.errMx.RLock()
 	firstErr := c.firstErr
@@ -83,63 +562,430 @@ func (c *conn) FirstError() error {
 	return firstErr
 }
 
+// EstimatedBandwidth estimates the available send and receive bandwidth in
+// bytes per second of the measured Conn underlying c as the peak rate over the
+// last 10 rate intervals during which the conn was active. Like BBR's windowed
+// max filter, this discounts intervals in which the application didn't have
+// enough data to fill the pipe, so it reflects what the path can sustain
+// rather than what was used. If c isn't (or doesn't wrap) a measured Conn, it
+// returns 0.
+func EstimatedBandwidth(c net.Conn) (sent float64, recv float64) {
+	mc, ok := findConn(c)
+	if !ok {
+		return 0, 0
+	}
+	return mc.sent.estimatedBandwidth(), mc.recv.estimatedBandwidth()
+}
+
+// ID returns the ID of the measured Conn underlying c, or "" if c isn't (or
+// doesn't wrap) a measured Conn.
+func ID(c net.Conn) string {
+	mc, ok := findConn(c)
+	if !ok {
+		return ""
+	}
+	return mc.id
+}
+
+func (c *conn) lastError() error {
+	c.errMx.RLock()
+	lastErr := c.lastErr
+	c.errMx.RUnlock()
+	return lastErr
+}
+
+// SetTag sets the tag k of the measured Conn underlying c to v, replacing any
+// existing value. It returns false if c isn't (or doesn't wrap) a measured
+// Conn.
+func SetTag(c net.Conn, k, v string) bool {
+	mc, ok := findConn(c)
+	if !ok {
+		return false
+	}
+	mc.setTag(k, v)
+	return true
+}
+
+func (c *conn) setTag(k, v string) {
+	c.metaMx.Lock()
+	if c.tags == nil {
+		c.tags = make(map[string]string)
+	}
+	c.tags[k] = v
+	c.metaMx.Unlock()
+}
+
 func (c *conn) Wrapped() net.Conn {
 	return c.Conn
 }
 
+func (c *conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+func (c *conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// age returns the monotonic time since the conn was wrapped.
+func (c *conn) age() time.Duration {
+	return now().Sub(c.start)
+}
+
+// activeTrackers counts the tracking goroutines that are currently running.
+var activeTrackers int64
+
+// ActiveTrackers returns the number of tracking goroutines that are currently
+// running. If it keeps growing, conns are being leaked without being closed,
+// which WithLeakTimeout guards against.
+func ActiveTrackers() int {
+	return int(atomic.LoadInt64(&activeTrackers))
+}
+
 func (c *conn) track(rateInterval time.Duration) {
+	atomic.AddInt64(&activeTrackers, 1)
+	defer atomic.AddInt64(&activeTrackers, -1)
 	c.sent.calc()
 	c.recv.calc()
 
+	// Directions configured WithRateIntervals are recalculated on their own
+	// tickers, the nil channels of the others never fire
+	var sentC, recvC <-chan time.Time
+	if c.sentInterval > 0 {
+		ticker := time.NewTicker(c.sentInterval)
+		defer ticker.Stop()
+		sentC = ticker.C
+	}
+	if c.recvInterval > 0 {
+		ticker := time.NewTicker(c.recvInterval)
+		defer ticker.Stop()
+		recvC = ticker.C
+	}
+
+	// Use a ticker rather than re-arming a timer on every pass, so that the
+	// direction-specific tickers can't starve the main interval
+	ticker := time.NewTicker(rateInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-c.closedCh:
-			c.sent.calc()
-			c.recv.calc()
-			if c.onFinish != nil {
-				c.onFinish(c)
-			}
+			c.finish()
 			return
-		case <-time.After(rateInterval):
+		case <-sentC:
 			c.sent.calc()
+		case <-recvC:
 			c.recv.calc()
+		case <-ticker.C:
+			if sentC == nil {
+				c.sent.calc()
+			}
+			if recvC == nil {
+				c.recv.calc()
+			}
+			c.sampleTCPInfo()
+			c.sampleTLSState()
+			c.aggregate()
+			c.recordHistory()
+			c.intervalReport(false)
+			c.maybeProbe()
+			if c.isLeaked() {
+				c.errMx.Lock()
+				c.leaked = true
+				c.errMx.Unlock()
+				if c.closeLeaked {
+					c.Close()
+				}
+				c.finish()
+				return
+			}
+		}
+	}
+}
+
+// finish finalizes the conn's stats and calls onFinish. It's only called once,
+// from the tracking goroutine.
+func (c *conn) finish() {
+	c.sent.calc()
+	c.recv.calc()
+	c.aggregate()
+	c.recordHistory()
+	c.enrich()
+	c.intervalReport(true)
+	c.reg.remove(c)
+	if c.quota != nil {
+		c.quota.quotas.release(c.quota.bucket)
+	}
+	c.deliverCapture()
+	c.runOnFinish()
+}
+
+// runOnFinish runs the onFinish callbacks, on the callback pool if there is
+// one.
+func (c *conn) runOnFinish() {
+	c.metaMx.Lock()
+	c.finished = true
+	var callbacks []func(Conn)
+	if c.onFinish != nil {
+		callbacks = append(callbacks, c.onFinish)
+	}
+	callbacks = append(callbacks, c.moreOnFinish...)
+	c.metaMx.Unlock()
+	if len(callbacks) == 0 {
+		return
+	}
+	runCallbacks := func() {
+		for _, onFinish := range callbacks {
+			runCallback(onFinish, c)
 		}
 	}
+	if c.callbackPool != nil {
+		c.callbackPool.submit(runCallbacks)
+	} else {
+		runCallbacks()
+	}
+}
+
+// intervalReport reports the change in stats since the last report, if the
+// report interval has elapsed or this is the final report. It is only called
+// from the tracking goroutine.
+func (c *conn) intervalReport(final bool) {
+	if c.report == nil {
+		return
+	}
+	stats := c.Stats()
+	lastDuration := time.Duration(0)
+	if c.lastReport != nil {
+		lastDuration = c.lastReport.Duration
+	}
+	if !final && stats.Duration-lastDuration < c.reportInterval {
+		return
+	}
+	delta := stats.Delta(c.lastReport)
+	runCallback(func(Conn) { c.report(c, delta) }, c)
+	c.lastReport = stats
+}
+
+// isLeaked checks whether the conn has been inactive for longer than the leak
+// timeout. A conn blocked in a Read or Write (e.g. waiting on a keep-alive or
+// long-poll) is in use and never considered leaked.
+func (c *conn) isLeaked() bool {
+	if c.leakAfter <= 0 || atomic.LoadInt64(&c.inFlight) > 0 {
+		return false
+	}
+	return c.idleFor() > c.leakAfter
+}
+
+// idleFor returns how long it's been since the conn last read or wrote,
+// counting from when it was wrapped if it hasn't yet.
+func (c *conn) idleFor() time.Duration {
+	lastActive := c.sent.lastActive()
+	if recvActive := c.recv.lastActive(); recvActive > lastActive {
+		lastActive = recvActive
+	}
+	if lastActive == 0 {
+		return c.age()
+	}
+	return now().Sub(lastActive)
+}
+
+func (c *conn) aggregate() {
+	for _, ag := range c.aggs {
+		ag.update(c)
+	}
+}
+
+func (c *conn) sampleTCPInfo() {
+	if c.sc == nil {
+		return
+	}
+	info, err := sampleTCPInfo(c.sc)
+	if err != nil {
+		return
+	}
+	c.sampleMx.Lock()
+	c.tcpInfo = info
+	c.sampleMx.Unlock()
+}
+
+// sampleTLSState records the TLS connection state once the handshake has
+// completed. It's sampled from the tracking loop rather than in Stats because
+// ConnectionState blocks while a handshake is in progress.
+func (c *conn) sampleTLSState() {
+	if c.tc == nil {
+		return
+	}
+	c.sampleMx.RLock()
+	done := c.tlsState != nil
+	c.sampleMx.RUnlock()
+	if done {
+		return
+	}
+	state := c.tc.ConnectionState()
+	if !state.HandshakeComplete {
+		return
+	}
+	c.sampleMx.Lock()
+	c.tlsState = &state
+	c.sampleMx.Unlock()
 }
 
 func (c *conn) Write(b []byte) (int, error) {
-	c.sent.begin(mtime.Now)
+	if c.countersOnly {
+		return c.countingWrite(b)
+	}
+	if c.quota != nil {
+		if err := c.quota.beforeWrite(c, len(b)); err != nil {
+			return 0, err
+		}
+	}
+	start := now()
+	c.sent.begin(now)
+	atomic.AddInt64(&c.inFlight, 1)
 	n, err := c.Conn.Write(b)
-	c.sent.advance(n, mtime.Now())
-	if err != nil && !isTimeout(err) {
-		c.storeError(err)
+	atomic.AddInt64(&c.inFlight, -1)
+	end := now()
+	c.sent.op(n, start, end)
+	atomic.AddInt64(&totalSent, int64(n))
+	if c.window != nil {
+		c.window.add(end, n, 0)
+	}
+	if c.th != nil {
+		c.th.advance(c, n)
 	}
+	if c.quota != nil {
+		c.quota.quotas.consume(c.quota.bucket, c, n)
+	}
+	if c.mirror != nil {
+		c.mirror.tee(c, Sent, b[:n])
+	}
+	if c.capture != nil {
+		c.capture.record(Sent, b[:n])
+	}
+	c.afterIO(err, &c.writeErr, &c.writeTimeouts)
 	return n, err
 }
 
 func (c *conn) Read(b []byte) (int, error) {
-	c.recv.begin(mtime.Now)
+	if c.countersOnly {
+		return c.countingRead(b)
+	}
+	start := now()
+	c.recv.begin(now)
+	atomic.AddInt64(&c.inFlight, 1)
 	n, err := c.Conn.Read(b)
-	c.recv.advance(n, mtime.Now())
-	if err != nil && !isTimeout(err) && err != io.EOF {
-		c.storeError(err)
+	atomic.AddInt64(&c.inFlight, -1)
+	end := now()
+	c.recv.op(n, start, end)
+	atomic.AddInt64(&totalRecv, int64(n))
+	if c.window != nil {
+		c.window.add(end, 0, n)
+	}
+	if c.th != nil {
+		c.th.advance(c, n)
+	}
+	if c.quota != nil {
+		c.quota.quotas.consume(c.quota.bucket, c, n)
+	}
+	if c.mirror != nil {
+		c.mirror.tee(c, Received, b[:n])
+	}
+	if c.capture != nil {
+		c.capture.record(Received, b[:n])
+	}
+	if err == io.EOF {
+		c.terminate(ClosedRemotely, TerminatedEOF)
+	} else {
+		c.afterIO(err, &c.readErr, &c.readTimeouts)
 	}
 	return n, err
 }
 
+func (c *conn) SetDeadline(t time.Time) error {
+	atomic.AddInt64(&c.deadlineSets, 1)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	atomic.AddInt64(&c.deadlineSets, 1)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	atomic.AddInt64(&c.deadlineSets, 1)
+	return c.Conn.SetWriteDeadline(t)
+}
+
 func (c *conn) Close() (err error) {
 	c.closeOnce.Do(func() {
+		if atomic.LoadInt32(&c.timedOut) == 1 {
+			c.terminate(ClosedLocally, TerminatedTimeout)
+		} else {
+			c.terminate(ClosedLocally, TerminatedLocally)
+		}
+		// Take a final sample while the socket is still open
+		c.sampleTCPInfo()
+		c.sampleTLSState()
 		err = c.Conn.Close()
+		if c.countersOnly {
+			c.runOnFinish()
+			return
+		}
 		close(c.closedCh)
 	})
 	return
 }
 
-func (c *conn) storeError(err error) {
+// storeError records an unexpected error, as well as recording it in
+// dirErr if that's the first error in that direction.
+func (c *conn) storeError(err error, dirErr *error) {
+	c.reg.countError(err)
 	c.errMx.Lock()
 	if c.firstErr == nil {
 		c.firstErr = err
 	}
+	if *dirErr == nil {
+		*dirErr = err
+	}
+	c.lastErr = err
+	c.errMx.Unlock()
+	if c.capture != nil {
+		c.capture.trigger(err)
+	}
+}
+
+// afterIO records the outcome of a Read or Write, storing unexpected errors in
+// dirErr. Timeouts aren't unexpected, but we count them in timeouts and
+// remember them in case the conn is closed because of one.
+func (c *conn) afterIO(err error, dirErr *error, timeouts *int64) {
+	if err == nil {
+		if atomic.LoadInt32(&c.timedOut) == 1 {
+			atomic.StoreInt32(&c.timedOut, 0)
+		}
+		return
+	}
+	if isTimeout(err) {
+		atomic.AddInt64(timeouts, 1)
+		atomic.StoreInt32(&c.timedOut, 1)
+		return
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		c.terminate(ClosedRemotely, TerminatedReset)
+	}
+	if c.errFilter != nil && !c.errFilter(err) {
+		return
+	}
+	c.storeError(err, dirErr)
+}
+
+// terminate records who initiated closing the conn and how, unless that's
+// already known.
+func (c *conn) terminate(closedBy ClosedBy, termination Termination) {
+	c.errMx.Lock()
+	if c.closedBy == NotClosed {
+		c.closedBy = closedBy
+		c.termination = termination
+	}
 	c.errMx.Unlock()
 }
 
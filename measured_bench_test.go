@@ -0,0 +1,79 @@
+package measured
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var benchBufferSizes = []int{512, 8192, 65536}
+
+var benchRateIntervals = []time.Duration{10 * time.Millisecond, 1 * time.Second}
+
+func BenchmarkRawConn(b *testing.B) {
+	for _, size := range benchBufferSizes {
+		b.Run(fmt.Sprintf("buf=%d", size), func(b *testing.B) {
+			benchmarkConn(b, &addrConn{}, size)
+		})
+	}
+}
+
+func BenchmarkMeasuredConn(b *testing.B) {
+	for _, size := range benchBufferSizes {
+		for _, interval := range benchRateIntervals {
+			b.Run(fmt.Sprintf("buf=%d/interval=%v", size, interval), func(b *testing.B) {
+				conn := Wrap(&addrConn{}, interval, nil)
+				defer conn.Close()
+				benchmarkConn(b, conn, size)
+			})
+		}
+	}
+}
+
+func BenchmarkCountersOnlyConn(b *testing.B) {
+	for _, size := range benchBufferSizes {
+		b.Run(fmt.Sprintf("buf=%d", size), func(b *testing.B) {
+			conn := Wrap(&addrConn{}, time.Second, nil, WithCountersOnly())
+			defer conn.Close()
+			benchmarkConn(b, conn, size)
+		})
+	}
+}
+
+func BenchmarkStats(b *testing.B) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	conn.Write(make([]byte, 100))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Stats()
+	}
+}
+
+func benchmarkConn(b *testing.B, conn net.Conn, size int) {
+	buf := make([]byte, size)
+	b.SetBytes(int64(2 * size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Write(buf)
+		conn.Read(buf)
+	}
+}
+
+// TestAllocationBudget makes sure that the Read/Write hot path doesn't
+// allocate.
+func TestAllocationBudget(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	buf := make([]byte, 8192)
+	allocs := testing.AllocsPerRun(1000, func() {
+		conn.Write(buf)
+		conn.Read(buf)
+	})
+	assert.EqualValues(t, 0, allocs, "Read and Write should not allocate")
+}
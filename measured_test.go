@@ -1,11 +1,15 @@
 package measured
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/getlantern/measured/testconn"
 	"github.com/getlantern/mockconn"
 	"github.com/stretchr/testify/assert"
 )
@@ -17,7 +21,7 @@ func TestMeasuredConn(t *testing.T) {
 	if !assert.NoError(t, err) {
 		return
 	}
-	conn := Wrap(&slowConn{wrapped}, rateInterval, nil)
+	conn := Wrap(slowConn(wrapped), rateInterval, nil)
 	n, err := conn.Write([]byte("12345678"))
 	if !assert.NoError(t, err) {
 		return
@@ -56,18 +60,206 @@ func TestMeasuredConn(t *testing.T) {
 	assert.True(t, stats.Duration > 10*time.Millisecond, "Stats should have some duration")
 }
 
-type slowConn struct {
-	net.Conn
+func TestUnwrap(t *testing.T) {
+	sd := mockconn.SucceedingDialer([]byte("1234567890"))
+	wrapped, err := sd.Dial("", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	conn := Wrap(wrapped, 50*time.Millisecond, nil)
+	defer conn.Close()
+	assert.Equal(t, wrapped, conn.Wrapped())
+	assert.Equal(t, wrapped, conn.Unwrap())
+	assert.Equal(t, wrapped, conn.NetConn())
 }
 
-func (c *slowConn) Write(b []byte) (int, error) {
-	time.Sleep(10 * time.Millisecond)
-	return c.Conn.Write(b)
+func TestEWMA(t *testing.T) {
+	conn := Wrap(slowConn(&addrConn{}), 10*time.Millisecond, nil, WithEWMA(time.Second))
+	conn.Write(make([]byte, 1000))
+	conn.Read(make([]byte, 1000))
+	conn.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	stats := conn.Stats()
+	assert.True(t, stats.SentEWMA > 0)
+	assert.True(t, stats.RecvEWMA > 0)
+}
+
+func TestTags(t *testing.T) {
+	sd := mockconn.SucceedingDialer([]byte("1234567890"))
+	wrapped, err := sd.Dial("", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	tags := map[string]string{"client": "5.0.1"}
+	conn := Wrap(wrapped, 50*time.Millisecond, nil, WithTags(tags), WithTags(map[string]string{"country": "nl"}))
+	defer conn.Close()
+	SetTag(conn, "protocol", "http")
+	SetTag(&decoratedConn{conn}, "client", "5.0.2")
+
+	assert.Equal(t, map[string]string{"client": "5.0.2", "country": "nl", "protocol": "http"}, conn.Stats().Tags)
+	assert.Equal(t, "5.0.1", tags["client"], "original tags should not be modified")
+
+	noTags := Wrap(wrapped, 50*time.Millisecond, nil)
+	defer noTags.Close()
+	assert.Nil(t, noTags.Stats().Tags)
+	assert.False(t, SetTag(wrapped, "protocol", "http"), "unmeasured conns can't be tagged")
 }
 
-func (c *slowConn) Read(b []byte) (int, error) {
+func TestPhases(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	assert.Nil(t, conn.Stats().Phases)
+
+	conn.Read(make([]byte, 3))
+	conn.Write(make([]byte, 2))
+	MarkPhase(conn, "connect_done")
 	time.Sleep(10 * time.Millisecond)
-	return c.Conn.Read(b)
+	conn.Write(make([]byte, 100))
+	MarkPhase(&decoratedConn{conn}, "transfer_done")
+	assert.False(t, MarkPhase(&addrConn{}, "connect_done"), "unmeasured conns have no phases")
+
+	phases := conn.Stats().Phases
+	if !assert.Len(t, phases, 2) {
+		return
+	}
+	assert.Equal(t, "connect_done", phases[0].Name)
+	assert.Equal(t, 2, phases[0].Sent)
+	assert.Equal(t, 3, phases[0].Recv)
+	assert.Equal(t, "transfer_done", phases[1].Name)
+	assert.Equal(t, 100, phases[1].Sent)
+	assert.Equal(t, 0, phases[1].Recv)
+	assert.True(t, phases[1].Duration >= 10*time.Millisecond)
+}
+
+func TestDirectionalErrors(t *testing.T) {
+	readErr := errors.New("read failed")
+	writeErr := errors.New("write failed")
+	conn := Wrap(&dirErrConn{readErr: readErr, writeErr: writeErr}, time.Second, nil)
+	defer conn.Close()
+	conn.Write(make([]byte, 1))
+	conn.Read(make([]byte, 1))
+
+	stats := conn.Stats()
+	assert.Equal(t, writeErr, conn.FirstError())
+	assert.Equal(t, readErr, stats.FirstReadError)
+	assert.Equal(t, writeErr, stats.FirstWriteError)
+}
+
+func TestErrorFilter(t *testing.T) {
+	ignored := errors.New("ignored")
+	conn := Wrap(&dirErrConn{readErr: fmt.Errorf("wrapped: %w", ignored), writeErr: errors.New("boom")}, time.Second, nil,
+		WithErrorFilter(func(err error) bool {
+			return !errors.Is(err, ignored)
+		}))
+	defer conn.Close()
+	conn.Read(make([]byte, 1))
+	assert.Nil(t, conn.FirstError())
+	conn.Write(make([]byte, 1))
+	assert.EqualError(t, conn.FirstError(), "boom")
+	assert.Nil(t, conn.Stats().FirstReadError)
+}
+
+func TestLeakTimeout(t *testing.T) {
+	finished := make(chan *Stats, 1)
+	conn := Wrap(&addrConn{}, 10*time.Millisecond, func(c Conn) {
+		finished <- c.Stats()
+	}, WithLeakTimeout(30*time.Millisecond))
+	conn.Write(make([]byte, 1))
+
+	select {
+	case stats := <-finished:
+		assert.True(t, stats.Leaked)
+		assert.Equal(t, NotClosed, stats.ClosedBy)
+		assert.Equal(t, 1, stats.SentTotal)
+	case <-time.After(1 * time.Second):
+		t.Fatal("leaked conn should have been finished")
+	}
+	assert.NotContains(t, reg.live(), conn)
+
+	active := Wrap(&addrConn{}, 10*time.Millisecond, func(c Conn) {
+		finished <- c.Stats()
+	}, WithLeakTimeout(30*time.Millisecond))
+	for i := 0; i < 6; i++ {
+		active.Write(make([]byte, 1))
+		time.Sleep(10 * time.Millisecond)
+	}
+	active.Close()
+	stats := <-finished
+	assert.False(t, stats.Leaked)
+	assert.Equal(t, ClosedLocally, stats.ClosedBy)
+}
+
+func TestLeakTimeoutBlockedRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	finished := make(chan *Stats, 1)
+	conn := Wrap(client, 10*time.Millisecond, func(c Conn) {
+		finished <- c.Stats()
+	}, WithLeakTimeout(30*time.Millisecond), WithLeakClose())
+	go conn.Read(make([]byte, 1))
+
+	select {
+	case <-finished:
+		t.Fatal("conn blocked in Read should not be considered leaked")
+	case <-time.After(150 * time.Millisecond):
+	}
+	conn.Close()
+	stats := <-finished
+	assert.False(t, stats.Leaked)
+}
+
+func TestClosedBy(t *testing.T) {
+	local := Wrap(&addrConn{}, time.Second, nil)
+	assert.Equal(t, NotClosed, local.Stats().ClosedBy)
+	local.Close()
+	assert.Equal(t, ClosedLocally, local.Stats().ClosedBy)
+
+	remote := Wrap(&dirErrConn{readErr: io.EOF}, time.Second, nil)
+	remote.Read(make([]byte, 1))
+	remote.Close()
+	assert.Equal(t, ClosedRemotely, remote.Stats().ClosedBy)
+	assert.Nil(t, remote.FirstError(), "EOF is not an unexpected error")
+	assert.Equal(t, "remote", ClosedRemotely.String())
+}
+
+func TestTermination(t *testing.T) {
+	terminate := func(readErr error) *Stats {
+		conn := Wrap(&dirErrConn{readErr: readErr}, time.Second, nil)
+		if readErr != nil {
+			conn.Read(make([]byte, 1))
+		}
+		conn.Close()
+		return conn.Stats()
+	}
+
+	assert.Equal(t, TerminatedEOF, terminate(io.EOF).Termination)
+	stats := terminate(&net.OpError{Op: "read", Err: syscall.ECONNRESET})
+	assert.Equal(t, TerminatedReset, stats.Termination)
+	assert.Equal(t, ClosedRemotely, stats.ClosedBy)
+	stats = terminate(&net.DNSError{Err: "timeout", IsTimeout: true})
+	assert.Equal(t, TerminatedTimeout, stats.Termination)
+	assert.Equal(t, ClosedLocally, stats.ClosedBy)
+	assert.Equal(t, TerminatedLocally, terminate(nil).Termination)
+
+	open := Wrap(&addrConn{}, time.Second, nil)
+	defer open.Close()
+	assert.Equal(t, NotTerminated, open.Stats().Termination)
+	assert.Equal(t, "reset", TerminatedReset.String())
+}
+
+type dirErrConn struct {
+	addrConn
+	readErr  error
+	writeErr error
+}
+
+func (c *dirErrConn) Read(b []byte) (int, error)  { return 0, c.readErr }
+func (c *dirErrConn) Write(b []byte) (int, error) { return 0, c.writeErr }
+
+// slowConn adds a fixed latency to every read and write.
+func slowConn(wrapped net.Conn) net.Conn {
+	return testconn.Wrap(wrapped, testconn.Profile{Latency: 10 * time.Millisecond})
 }
 
 func TestIsTimeout(t *testing.T) {
@@ -80,3 +272,160 @@ func TestIsTimeout(t *testing.T) {
 	assert.False(t, isTimeout(err2))
 	assert.False(t, isTimeout(err3))
 }
+
+func TestStatsConcurrentWithIO(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Millisecond, nil)
+	defer conn.Close()
+	done := make(chan interface{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			conn.Write([]byte("12345"))
+			conn.Read(make([]byte, 5))
+		}
+	}()
+	var last *Stats
+	for {
+		stats := conn.Stats()
+		if last != nil {
+			assert.True(t, stats.SentTotal >= last.SentTotal)
+			assert.True(t, stats.RecvTotal >= last.RecvTotal)
+		}
+		last = stats
+		select {
+		case <-done:
+			stats = conn.Stats()
+			assert.Equal(t, 5000, stats.SentTotal)
+			assert.Equal(t, 5000, stats.RecvTotal)
+			return
+		default:
+		}
+	}
+}
+
+func TestDeadlineTimeouts(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := Wrap(a, time.Second, nil)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 10))
+	assert.True(t, isTimeout(err))
+	_, err = conn.Write([]byte("hello"))
+	assert.True(t, isTimeout(err))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Millisecond))
+	conn.Read(make([]byte, 10))
+	conn.SetWriteDeadline(time.Time{})
+	conn.SetReadDeadline(time.Time{})
+
+	stats := conn.Stats()
+	assert.Equal(t, 2, stats.ReadTimeouts)
+	assert.Equal(t, 1, stats.WriteTimeouts)
+	assert.Equal(t, 4, stats.DeadlineSets)
+	assert.Nil(t, conn.FirstError(), "timeouts should not be unexpected errors")
+}
+
+func TestOps(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		conn.Write([]byte("1"))
+	}
+	conn.Write(nil)
+	conn.Read(make([]byte, 100))
+
+	stats := conn.Stats()
+	assert.Equal(t, 4, stats.WriteOps)
+	assert.Equal(t, 1, stats.ReadOps)
+	assert.Equal(t, 3, stats.SentTotal)
+
+	failing := Wrap(&errConn{err: errors.New("boom")}, time.Second, nil)
+	defer failing.Close()
+	failing.Read(make([]byte, 10))
+	assert.Equal(t, 1, failing.Stats().ReadOps, "failed operations should be counted")
+}
+
+func TestRateIntervals(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Hour, nil, WithRateIntervals(5*time.Millisecond, 0))
+	defer conn.Close()
+	// Let tracking start
+	time.Sleep(5 * time.Millisecond)
+	conn.Write(make([]byte, 100))
+	conn.Read(make([]byte, 100))
+	time.Sleep(5 * time.Millisecond)
+	conn.Write(make([]byte, 100))
+	conn.Read(make([]byte, 100))
+	time.Sleep(30 * time.Millisecond)
+
+	stats := conn.Stats()
+	assert.True(t, stats.SentMax > 0, "sent rate should be calculated at its own interval")
+	assert.EqualValues(t, 0, stats.RecvMax, "recv rate should only be calculated at the rate interval")
+}
+
+func TestRateIntervalsDontStarveRateInterval(t *testing.T) {
+	conn := Wrap(&addrConn{}, 50*time.Millisecond, nil, WithRateIntervals(5*time.Millisecond, 0), WithHistory(10))
+	defer conn.Close()
+	time.Sleep(300 * time.Millisecond)
+	assert.True(t, len(History(conn)) >= 3, "the rate interval should keep firing alongside shorter direction-specific ones")
+}
+
+func TestRateIntervalValidation(t *testing.T) {
+	assert.Equal(t, DefaultRateInterval, validRateInterval(0))
+	assert.Equal(t, DefaultRateInterval, validRateInterval(-time.Second))
+	assert.Equal(t, MinRateInterval, validRateInterval(time.Nanosecond))
+	assert.Equal(t, 50*time.Millisecond, validRateInterval(50*time.Millisecond))
+
+	conn := Wrap(&addrConn{}, 0, nil)
+	conn.Close()
+
+	_, err := WrapStrict(&addrConn{}, 0, nil)
+	assert.Equal(t, ErrInvalidRateInterval, err)
+	_, err = WrapStrict(&addrConn{}, time.Second, nil, WithRateIntervals(time.Microsecond, 0))
+	assert.Equal(t, ErrInvalidRateInterval, err)
+	conn, err = WrapStrict(&addrConn{}, time.Second, nil)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+}
+
+func TestLeakClose(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	finished := make(chan *Stats, 1)
+	Wrap(a, 10*time.Millisecond, func(c Conn) {
+		finished <- c.Stats()
+	}, WithLeakTimeout(30*time.Millisecond), WithLeakClose())
+
+	select {
+	case stats := <-finished:
+		assert.True(t, stats.Leaked)
+		assert.Equal(t, ClosedLocally, stats.ClosedBy)
+	case <-time.After(time.Second):
+		t.Fatal("leaked conn should have been finished")
+	}
+	_, err := b.Write([]byte("x"))
+	assert.Error(t, err, "leaked conn should have been closed")
+}
+
+func TestActiveTrackers(t *testing.T) {
+	waitFor := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return true
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return false
+	}
+	conns := make([]Conn, 10)
+	for i := range conns {
+		conns[i] = Wrap(&addrConn{}, time.Second, nil)
+	}
+	assert.True(t, waitFor(func() bool { return ActiveTrackers() >= 10 }))
+	before := ActiveTrackers()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	assert.True(t, waitFor(func() bool { return ActiveTrackers() <= before-10 }), "closing conns should stop their trackers")
+}
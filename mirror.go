@@ -0,0 +1,56 @@
+package measured
+
+import (
+	"sync"
+)
+
+// Direction distinguishes traffic sent from traffic received.
+type Direction int
+
+const (
+	// Sent is traffic written to the conn
+	Sent Direction = iota
+	// Received is traffic read from the conn
+	Received
+)
+
+func (d Direction) String() string {
+	if d == Sent {
+		return "sent"
+	}
+	return "received"
+}
+
+// MirrorSink receives mirrored traffic. b is only valid for the duration of the
+// call, so sinks that keep it need to copy it.
+type MirrorSink func(c Conn, d Direction, b []byte)
+
+// mirror tees a capped prefix of each direction of a conn into a sink.
+type mirror struct {
+	sink      MirrorSink
+	remaining [2]int
+	mx        sync.Mutex
+}
+
+func newMirror(limit int, sink MirrorSink) *mirror {
+	return &mirror{sink: sink, remaining: [2]int{limit, limit}}
+}
+
+// tee passes as much of b to the sink as the remaining limit for d allows.
+func (m *mirror) tee(c Conn, d Direction, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	m.mx.Lock()
+	n := m.remaining[d]
+	if n > len(b) {
+		n = len(b)
+	}
+	m.remaining[d] -= n
+	if n > 0 {
+		// Call the sink while holding the lock so that it sees each direction's
+		// bytes in order
+		m.sink(c, d, b[:n])
+	}
+	m.mx.Unlock()
+}
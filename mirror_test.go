@@ -0,0 +1,41 @@
+package measured
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/getlantern/mockconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirror(t *testing.T) {
+	sd := mockconn.SucceedingDialer([]byte("1234567890"))
+	wrapped, err := sd.Dial("", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var sent, recv bytes.Buffer
+	conn := Wrap(wrapped, time.Second, nil, WithMirror(6, func(c Conn, d Direction, b []byte) {
+		if d == Sent {
+			sent.Write(b)
+		} else {
+			recv.Write(b)
+		}
+	}))
+	defer conn.Close()
+
+	conn.Write([]byte("abcd"))
+	conn.Write([]byte("efgh"))
+	conn.Write([]byte("ijkl"))
+	conn.Read(make([]byte, 4))
+	conn.Read(make([]byte, 100))
+
+	assert.Equal(t, "abcdef", sent.String())
+	assert.Equal(t, "123456", recv.String())
+	stats := conn.Stats()
+	assert.Equal(t, 12, stats.SentTotal, "mirroring should not affect stats")
+	assert.Equal(t, 10, stats.RecvTotal)
+	assert.Equal(t, "sent", Sent.String())
+	assert.Equal(t, "received", Received.String())
+}
@@ -0,0 +1,96 @@
+package measured
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/mtime"
+)
+
+// LatencyHistogram counts operations by latency. The buckets are (in order)
+// less than 1 ms, 1 ms up to 10 ms, 10 ms up to 100 ms, 100 ms up to 1 s and
+// 1 s or more.
+type LatencyHistogram [5]int
+
+func latencyBucket(d time.Duration) int {
+	switch {
+	case d < time.Millisecond:
+		return 0
+	case d < 10*time.Millisecond:
+		return 1
+	case d < 100*time.Millisecond:
+		return 2
+	case d < time.Second:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Op is a request/response operation over a measured conn, started with
+// BeginOp.
+type Op struct {
+	c     *conn
+	start mtime.Instant
+	ended int32
+}
+
+// End ends the operation, recording its latency and whether it failed. Only
+// the first call to End is recorded. Ending a nil Op does nothing.
+func (o *Op) End(err error) {
+	if o == nil || !atomic.CompareAndSwapInt32(&o.ended, 0, 1) {
+		return
+	}
+	o.c.ops.record(now().Sub(o.start), err)
+}
+
+// BeginOp begins a request/response operation (e.g. an HTTP request or an RPC)
+// over the measured Conn underlying c. Ending it with Op.End records its
+// latency in Stats. If c isn't (or doesn't wrap) a measured Conn, BeginOp
+// returns nil.
+func BeginOp(c net.Conn) *Op {
+	mc, ok := findConn(c)
+	if !ok {
+		return nil
+	}
+	return &Op{c: mc, start: now()}
+}
+
+// opStats accumulates the latencies of the Ops on a conn.
+type opStats struct {
+	count     int
+	errors    int
+	total     time.Duration
+	max       time.Duration
+	latencies LatencyHistogram
+	mx        sync.Mutex
+}
+
+func (s *opStats) record(latency time.Duration, err error) {
+	s.mx.Lock()
+	s.count++
+	if err != nil {
+		s.errors++
+	}
+	s.total += latency
+	if latency > s.max {
+		s.max = latency
+	}
+	s.latencies[latencyBucket(latency)]++
+	s.mx.Unlock()
+}
+
+// fill populates the Op fields of stats.
+func (s *opStats) fill(stats *Stats) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	stats.Ops = s.count
+	stats.OpErrors = s.errors
+	if s.count > 0 {
+		stats.AvgOpLatency = s.total / time.Duration(s.count)
+	}
+	stats.MaxOpLatency = s.max
+	stats.OpLatencies = s.latencies
+}
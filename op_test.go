@@ -0,0 +1,38 @@
+package measured
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeginOp(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+
+	op := BeginOp(conn)
+	time.Sleep(15 * time.Millisecond)
+	op.End(nil)
+	op.End(errors.New("ignored"))
+	BeginOp(&decoratedConn{conn}).End(errors.New("failed"))
+	BeginOp(conn) // never ended
+	assert.NotPanics(t, func() { BeginOp(&addrConn{}).End(nil) }, "ops on unmeasured conns should be ignored")
+
+	stats := conn.Stats()
+	assert.Equal(t, 2, stats.Ops)
+	assert.Equal(t, 1, stats.OpErrors)
+	assert.True(t, stats.MaxOpLatency >= 15*time.Millisecond)
+	assert.True(t, stats.AvgOpLatency <= stats.MaxOpLatency)
+	assert.Equal(t, 1, stats.OpLatencies[0])
+	assert.Equal(t, 1, stats.OpLatencies[2])
+}
+
+func TestLatencyBucket(t *testing.T) {
+	assert.Equal(t, 0, latencyBucket(500*time.Microsecond))
+	assert.Equal(t, 1, latencyBucket(time.Millisecond))
+	assert.Equal(t, 2, latencyBucket(50*time.Millisecond))
+	assert.Equal(t, 3, latencyBucket(999*time.Millisecond))
+	assert.Equal(t, 4, latencyBucket(time.Minute))
+}
@@ -0,0 +1,381 @@
+package measured
+
+import (
+	"net"
+	"time"
+)
+
+// Option configures optional behavior of a measured Conn.
+type Option func(*opts)
+
+type opts struct {
+	id               string
+	instance         *Instance
+	dialStats        *DialStats
+	resolver         Resolver
+	idFunc           func(net.Conn) string
+	idSeparator      string
+	familyTag        bool
+	cardinalityGuard *CardinalityGuard
+	probeInterval    time.Duration
+	probe            func(Conn) error
+	countersOnly     bool
+	mirrorLimit      int
+	mirrorSink       MirrorSink
+	captureSize      int
+	captureFn        func(Conn, *Capture)
+	tcpInfo          bool
+	tags             map[string]string
+	labels           map[string]string
+	aggregators      []trafficSink
+	quotas           *Quotas
+	callbackPool     *CallbackPool
+	enrichers        []Enricher
+	thresholds       []threshold
+	errorFilter      func(error) bool
+	leakTimeout      time.Duration
+	leakClose        bool
+	maxConns         int
+	overflow         OverflowPolicy
+	proxyProtocol    bool
+	proxyTimeout     time.Duration
+	ewmaHalfLife     time.Duration
+	sentInterval     time.Duration
+	recvInterval     time.Duration
+	windowSize       time.Duration
+	windowResolution time.Duration
+	historySize      int
+	reportInterval   time.Duration
+	report           func(Conn, *Stats)
+}
+
+func buildOpts(options []Option) *opts {
+	o := &opts{}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// WithTCPInfo samples TCP_INFO (smoothed RTT, retransmits and congestion window)
+// from the underlying socket at each rate interval and exposes it in Stats.
+// This is only supported on Linux and only works if the chain of wrapped conns
+// ends in a syscall.Conn such as *net.TCPConn; otherwise it has no effect.
+func WithTCPInfo() Option {
+	return func(o *opts) {
+		o.tcpInfo = true
+	}
+}
+
+// WithTags attaches the given key/value metadata to the conn. Tags are carried
+// through to Stats and can be changed later using SetTag. Applying
+// WithTags more than once merges the tags.
+func WithTags(tags map[string]string) Option {
+	return func(o *opts) {
+		if o.tags == nil {
+			o.tags = make(map[string]string, len(tags))
+		}
+		for k, v := range tags {
+			o.tags[k] = v
+		}
+	}
+}
+
+// WithLabels attaches static labels (e.g. protocol=obfs4 or port=443) that are
+// merged into the tags reported in Stats. Unlike WithTags, the labels are
+// copied once when the Option is created and then shared by every conn it's
+// applied to, which makes it the cheap way to label all conns of a listener or
+// dialer. Tags set on individual conns take precedence over labels.
+func WithLabels(labels map[string]string) Option {
+	shared := make(map[string]string, len(labels))
+	for k, v := range labels {
+		shared[k] = v
+	}
+	return func(o *opts) {
+		if o.labels == nil {
+			o.labels = shared
+			return
+		}
+		merged := make(map[string]string, len(o.labels)+len(shared))
+		for k, v := range o.labels {
+			merged[k] = v
+		}
+		for k, v := range shared {
+			merged[k] = v
+		}
+		o.labels = merged
+	}
+}
+
+// WithAggregator accounts the conn's traffic to the given Aggregator. Traffic
+// is added at each rate interval and when the conn is closed.
+func WithAggregator(a *Aggregator) Option {
+	return func(o *opts) {
+		o.aggregators = append(o.aggregators, a)
+	}
+}
+
+// WithHeavyHitters accounts the conn's traffic to the given HeavyHitters
+// tracker. Like with WithAggregator, traffic is added at each rate interval and
+// when the conn is closed. Until it's stopped, the tracker's top buckets are
+// included in the snapshots served by Handler.
+func WithHeavyHitters(h *HeavyHitters) Option {
+	return func(o *opts) {
+		o.aggregators = append(o.aggregators, h)
+	}
+}
+
+// WithQuotas subjects the conn to the given Quotas.
+func WithQuotas(q *Quotas) Option {
+	return func(o *opts) {
+		o.quotas = q
+	}
+}
+
+// WithCallbackPool runs the conn's onFinish callbacks on the given
+// CallbackPool instead of on its tracking goroutine.
+func WithCallbackPool(p *CallbackPool) Option {
+	return func(o *opts) {
+		o.callbackPool = p
+	}
+}
+
+// WithRateIntervals recalculates send and receive rates at their own intervals
+// instead of at the rate interval passed to Wrap, which still drives all other
+// periodic work. A zero interval keeps using the rate interval for that
+// direction.
+func WithRateIntervals(sent time.Duration, recv time.Duration) Option {
+	return func(o *opts) {
+		o.sentInterval = sent
+		o.recvInterval = recv
+	}
+}
+
+// WithIDTags treats the conn's ID as hierarchical, splitting it at sep into
+// tags as described by SplitID, so that reports can be grouped by any level of
+// the hierarchy. The ID itself is unchanged, and tags set via WithTags or
+// SetTag take precedence.
+func WithIDTags(sep string) Option {
+	return func(o *opts) {
+		o.idSeparator = sep
+	}
+}
+
+// WithFamilyTag reports the conn's address family (tcp4, tcp6, unix and so on,
+// see FamilyTag) as a tag in its Stats. Tags set via WithTags or SetTag take
+// precedence.
+func WithFamilyTag() Option {
+	return func(o *opts) {
+		o.familyTag = true
+	}
+}
+
+// WithCardinalityGuard passes the tags reported in the conn's Stats through
+// the given CardinalityGuard. The conn's own tags are unchanged.
+func WithCardinalityGuard(g *CardinalityGuard) Option {
+	return func(o *opts) {
+		o.cardinalityGuard = g
+	}
+}
+
+// WithIdleProbe measures the round-trip time of conns that have been idle for
+// at least interval by calling probe, which should perform an
+// application-level ping over the conn and return once the response arrives.
+// Probes run at most once per interval on their own goroutine. Since idleness
+// is checked at each rate interval, interval should be at least the rate
+// interval.
+func WithIdleProbe(interval time.Duration, probe func(Conn) error) Option {
+	return func(o *opts) {
+		o.probeInterval = interval
+		o.probe = probe
+	}
+}
+
+// WithCountersOnly measures the conn in counters-only mode, for deployments
+// that need byte accounting on very many conns but can't afford per-interval
+// work. Reads and writes only update atomic byte counters, and there is no
+// tracking goroutine, so onFinish is called synchronously from Close (and only
+// if Close is called). Only the totals, overhead, ID, tags, errors, timing,
+// termination and, for conns dialed through DialerMiddleware, Dial are
+// populated in Stats. Counters-only conns don't appear in Aggregate, Handler or
+// DumpConnections, not even those of an Instance. Options other than WithID,
+// WithIDFunc, WithTags, WithLabels, WithIDTags, WithFamilyTag,
+// WithCardinalityGuard, WithErrorFilter, WithCallbackPool and WithInstance
+// (whose options are subject to the same restriction) are ignored.
+func WithCountersOnly() Option {
+	return func(o *opts) {
+		o.countersOnly = true
+	}
+}
+
+// WithMirror passes the first limit bytes sent and the first limit bytes
+// received on the conn to sink, for protocol debugging. Mirroring doesn't
+// affect the conn's stats.
+func WithMirror(limit int, sink MirrorSink) Option {
+	return func(o *opts) {
+		o.mirrorLimit = limit
+		o.mirrorSink = sink
+	}
+}
+
+// WithCapture starts recording the traffic on the conn once it encounters its
+// first unexpected error (or TriggerCapture is called), keeping the most recent
+// size bytes in each direction. The Capture is passed to fn when the conn
+// finishes, before onFinish is called. Nothing is recorded until capturing is
+// triggered, and the buffers are only allocated then.
+func WithCapture(size int, fn func(Conn, *Capture)) Option {
+	return func(o *opts) {
+		o.captureSize = size
+		o.captureFn = fn
+	}
+}
+
+// WithEnricher adds tags from the given Enricher to the conn before onFinish is
+// called. Enriched tags don't overwrite tags set via WithTags or SetTag.
+func WithEnricher(e Enricher) Option {
+	return func(o *opts) {
+		o.enrichers = append(o.enrichers, e)
+	}
+}
+
+// WithID sets the ID of the conn. If not specified, conns are assigned a unique
+// numeric ID.
+func WithID(id string) Option {
+	return func(o *opts) {
+		o.id = id
+	}
+}
+
+// WithIDFunc derives the ID of the conn from the wrapped net.Conn, e.g. from
+// its remote address. This is mostly useful with WrapListener, where it gives
+// each accepted conn an ID without the caller having to re-wrap it. If idFunc
+// returns "", the conn is assigned a unique numeric ID. WithID takes precedence
+// over WithIDFunc.
+func WithIDFunc(idFunc func(net.Conn) string) Option {
+	return func(o *opts) {
+		o.idFunc = idFunc
+	}
+}
+
+// WithThreshold calls fn the first time that the cumulative traffic (sent plus
+// received) on the conn reaches the given number of bytes. fn is called on the
+// goroutine performing the Read or Write that crossed the threshold. This
+// option can be applied multiple times to register several thresholds.
+func WithThreshold(bytes int64, fn func(Conn)) Option {
+	return func(o *opts) {
+		o.thresholds = append(o.thresholds, threshold{bytes, fn})
+	}
+}
+
+// WithErrorFilter lets callers decide which errors count as unexpected (and
+// are thus recorded as FirstError etc.). The filter is consulted in addition to
+// the built-in exclusion of timeouts and EOF and should return true for errors
+// that should be recorded, e.g. false for context.Canceled.
+func WithErrorFilter(filter func(error) bool) Option {
+	return func(o *opts) {
+		o.errorFilter = filter
+	}
+}
+
+// WithLeakTimeout treats conns that see no Read or Write activity for the given
+// timeout without being closed as leaked. Conns blocked in a Read or Write
+// aren't considered inactive. Leaked conns have their stats
+// finalized with Stats.Leaked set, onFinish is called and tracking stops, so
+// abandoned conns don't leak the tracking goroutine. The conn itself is left
+// open.
+func WithLeakTimeout(timeout time.Duration) Option {
+	return func(o *opts) {
+		o.leakTimeout = timeout
+	}
+}
+
+// WithLeakClose closes conns that WithLeakTimeout finds to be leaked, instead
+// of leaving them open.
+func WithLeakClose() Option {
+	return func(o *opts) {
+		o.leakClose = true
+	}
+}
+
+// WithMaxConns caps the number of conns that a measured listener tracks
+// concurrently, protecting memory on servers handling very many sockets. Once
+// the cap is reached, further conns are handled according to the given
+// OverflowPolicy. This option only applies to WrapListener.
+func WithMaxConns(maxConns int, overflow OverflowPolicy) Option {
+	return func(o *opts) {
+		o.maxConns = maxConns
+		o.overflow = overflow
+	}
+}
+
+// WithProxyProtocol makes a measured listener read a PROXY protocol (v1 or v2)
+// header from every accepted conn before measuring it, waiting at most
+// timeout (DefaultProxyHeaderTimeout if zero or negative) for the header to
+// arrive. Headers are read concurrently, so a slow client doesn't hold up
+// others, but at most 1024 at a time. The header bytes aren't counted as traffic and the conn's RemoteAddr
+// and LocalAddr report the addresses from the header, so that tags and IDs
+// reflect the real client. Conns without a valid header are closed. This
+// option only applies to WrapListener.
+func WithProxyProtocol(timeout time.Duration) Option {
+	return func(o *opts) {
+		o.proxyProtocol = true
+		o.proxyTimeout = timeout
+	}
+}
+
+// WithEWMA additionally calculates exponentially weighted moving average rates
+// with the given half-life, exposed as Stats.SentEWMA and Stats.RecvEWMA. Like
+// the min and max rates, the EWMA is updated at each rate interval and only
+// reflects periods during which the conn was active.
+func WithEWMA(halfLife time.Duration) Option {
+	return func(o *opts) {
+		o.ewmaHalfLife = halfLife
+	}
+}
+
+// WithWindow keeps a sliding window of the conn's recent traffic covering the
+// given size at the given resolution (e.g. 60 seconds at 1 second resolution),
+// so that RateOver can report throughput over recent periods.
+func WithWindow(size time.Duration, resolution time.Duration) Option {
+	return func(o *opts) {
+		o.windowSize = size
+		o.windowResolution = resolution
+	}
+}
+
+// WithHistory retains a sample of the bytes transferred during each of the
+// conn's most recent n rate intervals, which can be obtained using
+// History (e.g. to chart a conn's throughput over its lifetime).
+func WithHistory(n int) Option {
+	return func(o *opts) {
+		o.historySize = n
+	}
+}
+
+// WithIntervalReport calls report roughly every interval (rounded up to a
+// multiple of the rate interval) with the change in the conn's Stats since the
+// previous report, and a final time when the conn finishes. This lets backends
+// see per-interval traffic of long-lived conns instead of ever-growing totals.
+func WithIntervalReport(interval time.Duration, report func(c Conn, delta *Stats)) Option {
+	return func(o *opts) {
+		o.reportInterval = interval
+		o.report = report
+	}
+}
+
+// withDialStats attaches the given DialStats to the conn.
+func withDialStats(ds *DialStats) Option {
+	return func(o *opts) {
+		o.dialStats = ds
+	}
+}
+
+// WithResolver makes DialerMiddleware resolve host names itself using the
+// given Resolver and dial the resolved addresses in order, so that the time
+// spent resolving is reported separately from the time spent connecting. It
+// has no effect on conns that aren't dialed through DialerMiddleware.
+func WithResolver(resolver Resolver) Option {
+	return func(o *opts) {
+		o.resolver = resolver
+	}
+}
@@ -0,0 +1,116 @@
+// Package overhead runs standardized transfer scenarios through raw and
+// measured connections and reports the cost of measuring, so that callers can
+// check measured's overhead against a budget (e.g. as a release gate).
+package overhead
+
+import (
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/getlantern/measured"
+)
+
+// Scenario describes a standardized transfer. Each iteration writes and then
+// reads BufferSize bytes.
+type Scenario struct {
+	Name         string
+	BufferSize   int
+	Iterations   int
+	RateInterval time.Duration
+}
+
+// DefaultScenarios covers small, medium and large buffer sizes at a typical
+// rate interval.
+var DefaultScenarios = []Scenario{
+	{Name: "small", BufferSize: 512, Iterations: 200000, RateInterval: time.Second},
+	{Name: "medium", BufferSize: 8192, Iterations: 100000, RateInterval: time.Second},
+	{Name: "large", BufferSize: 65536, Iterations: 20000, RateInterval: time.Second},
+}
+
+// Measurement captures the cost of running a scenario through one kind of conn.
+type Measurement struct {
+	Elapsed     time.Duration
+	NsPerOp     float64
+	AllocsPerOp float64
+}
+
+// Result compares running a Scenario through a raw conn with running it
+// through a measured conn.
+type Result struct {
+	Scenario Scenario
+	Raw      Measurement
+	Measured Measurement
+}
+
+// Overhead returns the additional time spent per operation on the measured conn
+// as a fraction of the time spent on the raw conn (0.1 means 10% slower).
+func (r *Result) Overhead() float64 {
+	if r.Raw.NsPerOp == 0 {
+		return 0
+	}
+	return (r.Measured.NsPerOp - r.Raw.NsPerOp) / r.Raw.NsPerOp
+}
+
+// WithinBudget indicates whether the Overhead is at most maxOverhead and the
+// measured conn allocates at most maxExtraAllocs more per operation than the
+// raw conn.
+func (r *Result) WithinBudget(maxOverhead float64, maxExtraAllocs float64) bool {
+	return r.Overhead() <= maxOverhead && r.Measured.AllocsPerOp-r.Raw.AllocsPerOp <= maxExtraAllocs
+}
+
+// Run runs the given scenarios, or DefaultScenarios if none are specified.
+func Run(scenarios ...Scenario) []*Result {
+	if len(scenarios) == 0 {
+		scenarios = DefaultScenarios
+	}
+	results := make([]*Result, 0, len(scenarios))
+	for _, s := range scenarios {
+		result := &Result{Scenario: s}
+		result.Raw = measure(s, &discardConn{})
+		mc := measured.Wrap(&discardConn{}, s.RateInterval, nil)
+		result.Measured = measure(s, mc)
+		mc.Close()
+		results = append(results, result)
+	}
+	return results
+}
+
+func measure(s Scenario, conn net.Conn) Measurement {
+	b := make([]byte, s.BufferSize)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < s.Iterations; i++ {
+		conn.Write(b)
+		conn.Read(b)
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	m := Measurement{Elapsed: elapsed}
+	if s.Iterations > 0 {
+		m.NsPerOp = float64(elapsed.Nanoseconds()) / float64(s.Iterations)
+		m.AllocsPerOp = float64(after.Mallocs-before.Mallocs) / float64(s.Iterations)
+	}
+	return m
+}
+
+// discardConn is a net.Conn that does no I/O, which isolates the cost of the
+// measurement layer from the cost of the network.
+type discardConn struct{}
+
+func (c *discardConn) Read(b []byte) (int, error)         { return len(b), nil }
+func (c *discardConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (c *discardConn) Close() error                       { return nil }
+func (c *discardConn) LocalAddr() net.Addr                { return discardAddr{} }
+func (c *discardConn) RemoteAddr() net.Addr               { return discardAddr{} }
+func (c *discardConn) SetDeadline(t time.Time) error      { return nil }
+func (c *discardConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *discardConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type discardAddr struct{}
+
+func (discardAddr) Network() string { return "discard" }
+func (discardAddr) String() string  { return "discard" }
@@ -0,0 +1,28 @@
+package overhead
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	results := Run(Scenario{Name: "test", BufferSize: 1024, Iterations: 1000, RateInterval: time.Second})
+	if !assert.Len(t, results, 1) {
+		return
+	}
+	result := results[0]
+	assert.Equal(t, "test", result.Scenario.Name)
+	assert.True(t, result.Raw.NsPerOp > 0)
+	assert.True(t, result.Measured.NsPerOp > 0)
+	assert.True(t, result.WithinBudget(1000, 1000))
+}
+
+func TestOverhead(t *testing.T) {
+	r := &Result{Raw: Measurement{NsPerOp: 100}, Measured: Measurement{NsPerOp: 150, AllocsPerOp: 1}}
+	assert.EqualValues(t, 0.5, r.Overhead())
+	assert.True(t, r.WithinBudget(0.5, 1))
+	assert.False(t, r.WithinBudget(0.4, 1))
+	assert.False(t, r.WithinBudget(0.5, 0))
+}
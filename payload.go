@@ -0,0 +1,30 @@
+package measured
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// AddOverhead marks the given numbers of bytes sent and received over the
+// measured Conn underlying c as protocol overhead (e.g. framing, padding or
+// handshakes added by an upper layer) rather than payload, as reported in
+// Stats. It returns false if c isn't (or doesn't wrap) a measured Conn.
+func AddOverhead(c net.Conn, sent, recv int) bool {
+	mc, ok := findConn(c)
+	if !ok {
+		return false
+	}
+	atomic.AddInt64(&mc.sentOverhead, int64(sent))
+	atomic.AddInt64(&mc.recvOverhead, int64(recv))
+	return true
+}
+
+// payloadEfficiency returns the ratio of payload to total bytes, or 0 if
+// nothing was transferred.
+func payloadEfficiency(sent, recv, sentOverhead, recvOverhead int) float64 {
+	total := sent + recv
+	if total <= 0 {
+		return 0
+	}
+	return float64(total-sentOverhead-recvOverhead) / float64(total)
+}
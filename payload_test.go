@@ -0,0 +1,29 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddOverhead(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	assert.Equal(t, float64(0), conn.Stats().PayloadEfficiency, "efficiency should be 0 before any traffic")
+
+	conn.Write(make([]byte, 100))
+	conn.Read(make([]byte, 100))
+	AddOverhead(conn, 15, 30)
+	AddOverhead(&decoratedConn{conn}, 5, 0)
+	assert.False(t, AddOverhead(&addrConn{}, 5, 0), "unmeasured conns have no overhead")
+	stats := conn.Stats()
+	assert.Equal(t, 20, stats.SentOverhead)
+	assert.Equal(t, 30, stats.RecvOverhead)
+	assert.InDelta(t, 0.75, stats.PayloadEfficiency, 0.0001)
+
+	conn.Write(make([]byte, 100))
+	delta := conn.Stats().Delta(stats)
+	assert.Equal(t, 0, delta.SentOverhead)
+	assert.Equal(t, float64(1), delta.PayloadEfficiency, "delta efficiency should only reflect the interval")
+}
@@ -0,0 +1,67 @@
+package measured
+
+import (
+	"net"
+	"time"
+)
+
+// Phase describes a protocol phase of a conn (e.g. a SOCKS5 or HTTP CONNECT
+// handshake) that ended with a call to MarkPhase.
+type Phase struct {
+	// Name is the name passed to MarkPhase
+	Name string
+	// Duration is how long the phase took, measured from the previous mark (or
+	// from when the conn was wrapped for the first phase)
+	Duration time.Duration
+	// Sent and Recv are the bytes transferred during the phase
+	Sent int
+	Recv int
+}
+
+type mark struct {
+	name    string
+	elapsed time.Duration
+	sent    int
+	recv    int
+}
+
+// MarkPhase marks the end of a protocol phase (e.g. "connect_done") of the
+// measured Conn underlying c, recording how long it took and how many bytes
+// were transferred since the previous mark. It returns false if c isn't (or
+// doesn't wrap) a measured Conn.
+func MarkPhase(c net.Conn, name string) bool {
+	mc, ok := findConn(c)
+	if !ok {
+		return false
+	}
+	mc.markPhase(name)
+	return true
+}
+
+func (c *conn) markPhase(name string) {
+	sent, _, _, _ := c.sent.get()
+	recv, _, _, _ := c.recv.get()
+	m := mark{name, c.age(), sent, recv}
+	c.metaMx.Lock()
+	c.marks = append(c.marks, m)
+	c.metaMx.Unlock()
+}
+
+// phases converts marks to phases. It must be called with metaMx held.
+func (c *conn) phases() []Phase {
+	if len(c.marks) == 0 {
+		return nil
+	}
+	phases := make([]Phase, 0, len(c.marks))
+	var prev mark
+	for _, m := range c.marks {
+		phases = append(phases, Phase{
+			Name:     m.name,
+			Duration: m.elapsed - prev.elapsed,
+			Sent:     m.sent - prev.sent,
+			Recv:     m.recv - prev.recv,
+		})
+		prev = m
+	}
+	return phases
+}
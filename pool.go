@@ -0,0 +1,192 @@
+package measured
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// PoolStats provides statistics about how a Pool's connections are used.
+type PoolStats struct {
+	// Checkouts is the number of conns obtained from the pool with Get
+	Checkouts int
+	// Dials is the number of new conns dialed and DialErrors the number of
+	// failed dial attempts
+	Dials      int
+	DialErrors int
+	// Reuses is the number of checkouts satisfied using an idle conn
+	Reuses int
+	// Idle is the number of conns currently idle in the pool
+	Idle int
+	// AvgDialLatency is the average time taken by successful dials
+	AvgDialLatency time.Duration
+	// AvgIdleTime is the average time that reused conns spent idle before being
+	// checked out again
+	AvgIdleTime time.Duration
+	// SentTotal and RecvTotal are the bytes transferred by all conns that the
+	// pool has dialed, including ones that have since been closed
+	SentTotal int
+	RecvTotal int
+}
+
+// ReuseRate returns the fraction of checkouts that reused an idle conn.
+func (s *PoolStats) ReuseRate() float64 {
+	if s.Checkouts == 0 {
+		return 0
+	}
+	return float64(s.Reuses) / float64(s.Checkouts)
+}
+
+type idleConn struct {
+	conn  Conn
+	since time.Time
+}
+
+// Pool is a pool of measured conns to a single upstream (e.g. an upstream
+// proxy) that tracks how effectively conns are reused.
+type Pool struct {
+	dial         func(ctx context.Context) (net.Conn, error)
+	maxIdle      int
+	rateInterval time.Duration
+	onFinish     func(Conn)
+	options      []Option
+
+	idle         []*idleConn
+	isIdle       map[Conn]bool
+	live         map[Conn]bool
+	stats        PoolStats
+	dialTime     time.Duration
+	idleTime     time.Duration
+	finishedSent int
+	finishedRecv int
+	closed       bool
+	mx           sync.Mutex
+}
+
+// NewPool constructs a Pool that dials new conns using dial and keeps up to
+// maxIdle conns around for reuse. Dialed conns are wrapped with the given
+// rateInterval, onFinish and options.
+func NewPool(dial func(ctx context.Context) (net.Conn, error), maxIdle int, rateInterval time.Duration, onFinish func(Conn), options ...Option) *Pool {
+	return &Pool{
+		dial:         dial,
+		maxIdle:      maxIdle,
+		rateInterval: rateInterval,
+		onFinish:     onFinish,
+		options:      options,
+		isIdle:       make(map[Conn]bool),
+		live:         make(map[Conn]bool),
+	}
+}
+
+// Get checks out a conn from the pool, reusing the most recently returned idle
+// conn if there is one and dialing a new one otherwise.
+func (p *Pool) Get(ctx context.Context) (Conn, error) {
+	p.mx.Lock()
+	if n := len(p.idle); n > 0 {
+		ic := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		delete(p.isIdle, ic.conn)
+		p.stats.Checkouts++
+		p.stats.Reuses++
+		p.idleTime += time.Since(ic.since)
+		p.mx.Unlock()
+		return ic.conn, nil
+	}
+	p.mx.Unlock()
+
+	start := time.Now()
+	wrapped, err := p.dial(ctx)
+	elapsed := time.Since(start)
+	if err != nil {
+		p.mx.Lock()
+		p.stats.DialErrors++
+		p.mx.Unlock()
+		return nil, err
+	}
+	conn := Wrap(wrapped, p.rateInterval, p.finished, p.options...)
+	p.mx.Lock()
+	p.stats.Checkouts++
+	p.stats.Dials++
+	p.dialTime += elapsed
+	p.live[conn] = true
+	p.mx.Unlock()
+	return conn, nil
+}
+
+// Put returns a healthy conn to the pool for reuse. If the pool already has
+// maxIdle idle conns or has been closed, the conn is closed instead. Conns that
+// encountered errors should be closed rather than returned to the pool.
+// Putting a conn that is already idle has no effect.
+func (p *Pool) Put(conn Conn) {
+	p.mx.Lock()
+	if p.isIdle[conn] {
+		p.mx.Unlock()
+		return
+	}
+	if p.closed || len(p.idle) >= p.maxIdle || !p.live[conn] {
+		p.mx.Unlock()
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, &idleConn{conn, time.Now()})
+	p.isIdle[conn] = true
+	p.mx.Unlock()
+}
+
+// Stats returns statistics about the pool's usage so far.
+func (p *Pool) Stats() *PoolStats {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	stats := p.stats
+	stats.Idle = len(p.idle)
+	if stats.Dials > 0 {
+		stats.AvgDialLatency = p.dialTime / time.Duration(stats.Dials)
+	}
+	if stats.Reuses > 0 {
+		stats.AvgIdleTime = p.idleTime / time.Duration(stats.Reuses)
+	}
+	stats.SentTotal = p.finishedSent
+	stats.RecvTotal = p.finishedRecv
+	for conn := range p.live {
+		connStats := conn.Stats()
+		stats.SentTotal += connStats.SentTotal
+		stats.RecvTotal += connStats.RecvTotal
+	}
+	return &stats
+}
+
+// Close closes all idle conns and prevents further conns from being returned to
+// the pool. Checked out conns are unaffected.
+func (p *Pool) Close() error {
+	p.mx.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.isIdle = make(map[Conn]bool)
+	p.closed = true
+	p.mx.Unlock()
+	for _, ic := range idle {
+		ic.conn.Close()
+	}
+	return nil
+}
+
+func (p *Pool) finished(conn Conn) {
+	stats := conn.Stats()
+	p.mx.Lock()
+	delete(p.live, conn)
+	delete(p.isIdle, conn)
+	p.finishedSent += stats.SentTotal
+	p.finishedRecv += stats.RecvTotal
+	for i, ic := range p.idle {
+		if ic.conn == conn {
+			// Conn was closed while idle, don't hand it out again
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			break
+		}
+	}
+	p.mx.Unlock()
+	if p.onFinish != nil {
+		p.onFinish(conn)
+	}
+}
@@ -0,0 +1,90 @@
+package measured
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	finished := make(chan Conn, 10)
+	p := NewPool(func(ctx context.Context) (net.Conn, error) {
+		return &addrConn{}, nil
+	}, 1, 10*time.Millisecond, func(c Conn) { finished <- c })
+
+	c1, err := p.Get(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	c2, err := p.Get(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	c1.Write(make([]byte, 10))
+	c2.Read(make([]byte, 20))
+	p.Put(c1)
+	p.Put(c2) // exceeds maxIdle, should be closed
+	select {
+	case c := <-finished:
+		assert.Equal(t, c2, c)
+	case <-time.After(time.Second):
+		t.Fatal("excess conn should have been closed")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	c3, err := p.Get(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, c1, c3, "idle conn should be reused")
+	c3.Write(make([]byte, 5))
+
+	stats := p.Stats()
+	assert.Equal(t, 3, stats.Checkouts)
+	assert.Equal(t, 2, stats.Dials)
+	assert.Equal(t, 1, stats.Reuses)
+	assert.EqualValues(t, 1.0/3.0, stats.ReuseRate())
+	assert.True(t, stats.AvgIdleTime >= 5*time.Millisecond)
+	assert.Equal(t, 15, stats.SentTotal)
+	assert.Equal(t, 20, stats.RecvTotal)
+
+	p.Close()
+	p.Put(c3)
+	<-finished
+	assert.Equal(t, 0, p.Stats().Idle)
+	assert.Equal(t, 15, p.Stats().SentTotal, "totals should survive closing")
+}
+
+func TestPoolDialError(t *testing.T) {
+	p := NewPool(func(ctx context.Context) (net.Conn, error) {
+		return nil, errors.New("unreachable")
+	}, 1, time.Second, nil)
+	_, err := p.Get(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, p.Stats().DialErrors)
+	assert.Equal(t, 0, p.Stats().Checkouts)
+}
+
+func TestPoolDoublePut(t *testing.T) {
+	p := NewPool(func(ctx context.Context) (net.Conn, error) {
+		return &addrConn{}, nil
+	}, 2, time.Second, nil)
+	defer p.Close()
+	c, err := p.Get(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	p.Put(c)
+	p.Put(c)
+	assert.Equal(t, 1, p.Stats().Idle, "duplicate Put should be ignored")
+
+	a, _ := p.Get(context.Background())
+	b, _ := p.Get(context.Background())
+	assert.Equal(t, c, a)
+	assert.NotEqual(t, a, b, "a conn must not be handed out twice")
+	b.Close()
+}
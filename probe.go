@@ -0,0 +1,65 @@
+package measured
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/mtime"
+)
+
+// errProbePanicked is recorded as the error of a probe function that panicked.
+var errProbePanicked = errors.New("probe panicked")
+
+// prober periodically probes the round-trip time of an idle conn.
+type prober struct {
+	probing   int32
+	interval  time.Duration
+	probe     func(Conn) error
+	lastProbe mtime.Instant
+	rtt       time.Duration
+	probes    int
+	errors    int
+	mx        sync.Mutex
+}
+
+// maybeProbe starts a probe if the conn has been idle for the probe interval
+// and no probe is in progress. It's only called from the tracking goroutine.
+func (c *conn) maybeProbe() {
+	p := c.prober
+	if p == nil || atomic.LoadInt32(&p.probing) == 1 {
+		return
+	}
+	if c.idleFor() < p.interval {
+		return
+	}
+	if p.lastProbe != 0 && now().Sub(p.lastProbe) < p.interval {
+		return
+	}
+	p.lastProbe = now()
+	atomic.StoreInt32(&p.probing, 1)
+	go func() {
+		defer atomic.StoreInt32(&p.probing, 0)
+		start := now()
+		err := errProbePanicked
+		runCallback(func(c Conn) { err = p.probe(c) }, c)
+		rtt := now().Sub(start)
+		p.mx.Lock()
+		p.probes++
+		if err != nil {
+			p.errors++
+		} else {
+			p.rtt = rtt
+		}
+		p.mx.Unlock()
+	}()
+}
+
+func (p *prober) fill(stats *Stats) {
+	p.mx.Lock()
+	stats.ProbeRTT = p.rtt
+	stats.Probes = p.probes
+	stats.ProbeErrors = p.errors
+	p.mx.Unlock()
+}
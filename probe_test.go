@@ -0,0 +1,72 @@
+package measured
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdleProbe(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	go func() {
+		buf := make([]byte, 4)
+		for {
+			if _, err := b.Read(buf); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+			if _, err := b.Write([]byte("pong")); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn := Wrap(a, 5*time.Millisecond, nil, WithIdleProbe(20*time.Millisecond, func(c Conn) error {
+		if _, err := c.Write([]byte("ping")); err != nil {
+			return err
+		}
+		_, err := c.Read(make([]byte, 4))
+		return err
+	}))
+	defer conn.Close()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, conn.Stats().Probes, "should wait until idle for the interval")
+	time.Sleep(100 * time.Millisecond)
+	stats := conn.Stats()
+	assert.True(t, stats.Probes >= 2, "should have probed repeatedly, got %d", stats.Probes)
+	assert.True(t, stats.Probes <= 6, "should probe at most once per interval, got %d", stats.Probes)
+	assert.Equal(t, 0, stats.ProbeErrors)
+	assert.True(t, stats.ProbeRTT >= time.Millisecond)
+}
+
+func TestIdleProbeError(t *testing.T) {
+	conn := Wrap(&addrConn{}, 5*time.Millisecond, nil, WithIdleProbe(5*time.Millisecond, func(c Conn) error {
+		return errors.New("no pong")
+	}))
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	stats := conn.Stats()
+	assert.True(t, stats.ProbeErrors > 0)
+	assert.Equal(t, stats.Probes, stats.ProbeErrors)
+	assert.EqualValues(t, 0, stats.ProbeRTT)
+}
+
+func TestIdleProbePanic(t *testing.T) {
+	var probes int32
+	conn := Wrap(&addrConn{}, 5*time.Millisecond, nil, WithIdleProbe(5*time.Millisecond, func(Conn) error {
+		atomic.AddInt32(&probes, 1)
+		panic("probe")
+	}))
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	stats := conn.Stats()
+	assert.True(t, stats.ProbeErrors > 0, "panicking probes should count as failed")
+	assert.Equal(t, stats.Probes, stats.ProbeErrors)
+	assert.True(t, atomic.LoadInt32(&probes) > 1, "probing should continue after a panic")
+}
@@ -0,0 +1,268 @@
+package measured
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidProxyHeader indicates that a conn accepted by a listener expecting
+// the PROXY protocol didn't start with a valid PROXY header.
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// proxyV1MaxLength is the maximum length of a v1 header, including CRLF
+	proxyV1MaxLength = 107
+	proxyV2HeaderLen = 16
+	// DefaultProxyHeaderTimeout is used by WithProxyProtocol when given a zero
+	// or negative timeout.
+	DefaultProxyHeaderTimeout = 5 * time.Second
+	// maxPendingProxyHeaders limits how many PROXY headers a listener reads at
+	// once
+	maxPendingProxyHeaders = 1024
+)
+
+// acceptResult is a conn whose PROXY header has been read, or an error from
+// the underlying listener.
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// acceptProxied returns the next conn whose PROXY header has been read. Headers
+// are read on a goroutine per conn, so that clients that are slow to send
+// theirs don't hold up others.
+func (l *listener) acceptProxied() (net.Conn, error) {
+	l.proxyOnce.Do(func() {
+		go l.readProxyHeaders()
+	})
+	select {
+	case result := <-l.accepted:
+		return result.conn, result.err
+	case <-l.acceptDone:
+		return nil, l.acceptErr
+	case <-l.closedCh:
+		return nil, &net.OpError{Op: "accept", Net: l.Addr().Network(), Addr: l.Addr(), Err: net.ErrClosed}
+	}
+}
+
+// readProxyHeaders accepts conns from the underlying listener and reads their
+// PROXY headers, handing the results to acceptProxied. Once the underlying
+// listener is closed, its error is returned by every call to acceptProxied.
+// Conns are only accepted from the underlying listener while fewer than
+// maxPendingProxyHeaders are waiting for their header or to be accepted.
+func (l *listener) readProxyHeaders() {
+	for {
+		select {
+		case l.headerSlots <- struct{}{}:
+		case <-l.closedCh:
+			return
+		}
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			<-l.headerSlots
+			if errors.Is(err, net.ErrClosed) {
+				l.acceptErr = err
+				close(l.acceptDone)
+				return
+			}
+			select {
+			case l.accepted <- acceptResult{err: err}:
+			case <-l.closedCh:
+				return
+			}
+			continue
+		}
+		go func() {
+			defer func() { <-l.headerSlots }()
+			pc, err := readProxyHeader(conn, l.proxyTimeout)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			select {
+			case l.accepted <- acceptResult{conn: pc}:
+			case <-l.closedCh:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// proxyConn is a conn whose PROXY header has been consumed. It reports the
+// addresses from the header and returns any data read along with the header
+// before reading from the underlying conn.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if c.r != nil {
+		n, err := c.r.Read(b)
+		if c.r.Buffered() == 0 {
+			c.r = nil
+		}
+		return n, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr { return c.remote }
+func (c *proxyConn) LocalAddr() net.Addr  { return c.local }
+
+// Wrapped implements the interface used by Unwrap.
+func (c *proxyConn) Wrapped() net.Conn { return c.Conn }
+
+// readProxyHeader reads a PROXY v1 or v2 header from conn, waiting at most
+// timeout (or DefaultProxyHeaderTimeout if it's not positive) for it to
+// arrive. The returned conn reports the client and
+// destination addresses from the header, except for LOCAL (v2) and UNKNOWN
+// (v1) headers, which keep the addresses of conn.
+func readProxyHeader(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	pc := &proxyConn{Conn: conn, r: r, remote: conn.RemoteAddr(), local: conn.LocalAddr()}
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] == proxyV2Signature[0] {
+		err = pc.parseV2()
+	} else {
+		err = pc.parseV1()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	if r.Buffered() == 0 {
+		pc.r = nil
+	}
+	return pc, nil
+}
+
+func (c *proxyConn) parseV1() error {
+	var line []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return ErrInvalidProxyHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return ErrInvalidProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return ErrInvalidProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil
+	case "TCP4", "TCP6":
+	default:
+		return ErrInvalidProxyHeader
+	}
+	if len(fields) != 6 {
+		return ErrInvalidProxyHeader
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || srcErr != nil || dstErr != nil {
+		return ErrInvalidProxyHeader
+	}
+	c.remote = &net.TCPAddr{IP: src, Port: int(srcPort)}
+	c.local = &net.TCPAddr{IP: dst, Port: int(dstPort)}
+	return nil
+}
+
+func (c *proxyConn) parseV2() error {
+	header := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) || header[12]>>4 != 2 {
+		return ErrInvalidProxyHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	switch header[12] & 0x0f {
+	case 0x0:
+		// LOCAL, e.g. a health check by the proxy itself
+		return nil
+	case 0x1:
+		// PROXY
+	default:
+		return ErrInvalidProxyHeader
+	}
+	family, transport := header[13]>>4, header[13]&0x0f
+	var ipLen int
+	switch family {
+	case 0x0:
+		// AF_UNSPEC
+		return nil
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	case 0x3:
+		if len(payload) < 216 {
+			return ErrInvalidProxyHeader
+		}
+		network := "unix"
+		if transport == 0x2 {
+			network = "unixgram"
+		}
+		c.remote = &net.UnixAddr{Name: string(bytes.TrimRight(payload[:108], "\x00")), Net: network}
+		c.local = &net.UnixAddr{Name: string(bytes.TrimRight(payload[108:216], "\x00")), Net: network}
+		return nil
+	default:
+		return ErrInvalidProxyHeader
+	}
+	if len(payload) < 2*ipLen+4 {
+		return ErrInvalidProxyHeader
+	}
+	src := net.IP(payload[:ipLen])
+	dst := net.IP(payload[ipLen : 2*ipLen])
+	srcPort := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+	switch transport {
+	case 0x1:
+		c.remote = &net.TCPAddr{IP: src, Port: srcPort}
+		c.local = &net.TCPAddr{IP: dst, Port: dstPort}
+	case 0x2:
+		c.remote = &net.UDPAddr{IP: src, Port: srcPort}
+		c.local = &net.UDPAddr{IP: dst, Port: dstPort}
+	default:
+		return ErrInvalidProxyHeader
+	}
+	return nil
+}
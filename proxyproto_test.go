@@ -0,0 +1,223 @@
+package measured
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readerConn is a conn that reads from r.
+type readerConn struct {
+	addrConn
+	r io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error)        { return c.r.Read(b) }
+func (c *readerConn) SetReadDeadline(t time.Time) error { return nil }
+
+func proxyV2Header(cmd, family byte, addrs []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	original := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x30, 0x39, 0x01, 0xbb}
+	v6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x30, 0x39, 0x01, 0xbb)
+	tests := []struct {
+		name   string
+		header []byte
+		remote string
+		local  string
+		err    bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\n"), "192.0.2.1:12345", "198.51.100.1:443", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n"), "[2001:db8::1]:12345", "[2001:db8::2]:443", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), original.String(), "", false},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 123456 443\r\n"), "", "", true},
+		{"v1 no crlf", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\n"), "", "", true},
+		{"v1 too long", bytes.Repeat([]byte("PROXY "), 30), "", "", true},
+		{"not proxy", []byte("GET / HTTP/1.1\r\n"), "", "", true},
+		{"v2 tcp4", proxyV2Header(0x1, 0x11, v4), "192.0.2.1:12345", "198.51.100.1:443", false},
+		{"v2 tcp6 with tlv", proxyV2Header(0x1, 0x21, append(v6, 0x04, 0, 1, 'x')), "[2001:db8::1]:12345", "[2001:db8::2]:443", false},
+		{"v2 local", proxyV2Header(0x0, 0x00, nil), original.String(), "", false},
+		{"v2 short", proxyV2Header(0x1, 0x11, v4[:8]), "", "", true},
+		{"v2 bad version", append(append([]byte{}, proxyV2Signature...), 0x11, 0x11, 0, 0), "", "", true},
+	}
+	for _, test := range tests {
+		raw := &readerConn{addrConn{remote: original}, io.MultiReader(bytes.NewReader(test.header), bytes.NewReader([]byte("payload")))}
+		conn, err := readProxyHeader(raw, 0)
+		if test.err {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+		assert.Equal(t, test.remote, conn.RemoteAddr().String(), test.name)
+		if test.local != "" {
+			assert.Equal(t, test.local, conn.LocalAddr().String(), test.name)
+		}
+		rest, _ := ioutil.ReadAll(conn)
+		assert.Equal(t, "payload", string(rest), test.name)
+	}
+}
+
+func TestListenerProxyProtocol(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	finished := make(chan *Stats, 1)
+	ml := WrapListener(l, time.Second, func(conn Conn) {
+		finished <- conn.Stats()
+	}, WithProxyProtocol(time.Second), WithIDFunc(func(c net.Conn) string {
+		return c.RemoteAddr().String()
+	}))
+	defer ml.Close()
+
+	go func() {
+		// a conn without a header is dropped
+		bad, err := net.Dial("tcp", l.Addr().String())
+		if err == nil {
+			bad.Write([]byte("hello\r\n"))
+			defer bad.Close()
+		}
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\nhello"))
+	}()
+
+	conn, err := ml.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "192.0.2.1:12345", conn.RemoteAddr().String())
+	b := make([]byte, 5)
+	_, err = io.ReadFull(conn, b)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	conn.Close()
+
+	select {
+	case stats := <-finished:
+		assert.Equal(t, "192.0.2.1:12345", stats.ID)
+		assert.Equal(t, 5, stats.RecvTotal, "header bytes should not be counted")
+	case <-time.After(5 * time.Second):
+		t.Fatal("conn didn't finish")
+	}
+}
+
+func TestListenerProxyProtocolSlowClient(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ml := WrapListener(l, time.Second, nil, WithProxyProtocol(2*time.Second))
+
+	// a client that connects but never sends a header
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer silent.Close()
+	time.Sleep(20 * time.Millisecond)
+	good, err := net.Dial("tcp", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer good.Close()
+	good.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\n"))
+
+	start := time.Now()
+	conn, err := ml.Accept()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "192.0.2.1:12345", conn.RemoteAddr().String())
+		conn.Close()
+	}
+	assert.True(t, time.Since(start) < time.Second, "silent client should not hold up others")
+
+	assert.NoError(t, ml.Close())
+	_, err = ml.Accept()
+	assert.True(t, errors.Is(err, net.ErrClosed), "Accept should fail once closed, got %v", err)
+}
+
+func TestListenerProxyProtocolInnerClosed(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ml := WrapListener(l, time.Second, nil, WithProxyProtocol(time.Second))
+	defer ml.Close()
+	l.Close()
+
+	for i := 0; i < 2; i++ {
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := ml.Accept()
+			errCh <- err
+		}()
+		select {
+		case err := <-errCh:
+			assert.True(t, errors.Is(err, net.ErrClosed), "Accept should fail once the inner listener is closed, got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("Accept should not block once the inner listener is closed")
+		}
+	}
+}
+
+func TestListenerProxyProtocolPendingLimit(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ml := WrapListener(l, time.Second, nil, WithProxyProtocol(5*time.Second))
+	defer ml.Close()
+	ml.(*listener).headerSlots = make(chan struct{}, 1)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ml.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	time.Sleep(20 * time.Millisecond)
+	good, err := net.Dial("tcp", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer good.Close()
+	good.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\n"))
+
+	select {
+	case <-accepted:
+		t.Fatal("should not read more headers at once than allowed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	silent.Close()
+	select {
+	case conn := <-accepted:
+		assert.Equal(t, "192.0.2.1:12345", conn.RemoteAddr().String())
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("conn should be accepted once the pending header fails")
+	}
+}
@@ -0,0 +1,228 @@
+package measured
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/getlantern/mtime"
+)
+
+// ErrQuotaExceeded is returned by Write on conns whose quota is exhausted
+// under the QuotaClose policy.
+var ErrQuotaExceeded = errors.New("measured: quota exceeded")
+
+// QuotaPolicy determines what happens to conns whose quota is exhausted.
+type QuotaPolicy int
+
+const (
+	// QuotaClose fails writes with ErrQuotaExceeded and closes the conn
+	QuotaClose QuotaPolicy = iota
+	// QuotaThrottle limits writes to QuotaConfig.ThrottleRate
+	QuotaThrottle
+)
+
+// QuotaConfig configures Quotas.
+type QuotaConfig struct {
+	// Bucket determines the key under which a conn's traffic is counted,
+	// defaults to ByID.
+	Bucket BucketFunc
+	// Limit is the default number of bytes (sent plus received) that each
+	// bucket may transfer per Period. Zero means unlimited. Use SetLimit to
+	// override it for individual buckets.
+	Limit int64
+	// Period is how often budgets roll over. If zero, they never do, but a
+	// bucket's budget is forgotten once it has no more open conns. With a
+	// non-zero Period, budgets of buckets without open conns are forgotten
+	// once their period has elapsed.
+	Period time.Duration
+	// Policy determines how exhausted conns are treated.
+	Policy QuotaPolicy
+	// ThrottleRate is the rate in bytes per second to which writes are limited
+	// under QuotaThrottle. If zero, exhausted conns are only reported.
+	ThrottleRate int
+	// OnExhausted, if set, is called the first time a bucket exhausts its
+	// budget in a period, with the conn whose traffic exhausted it.
+	OnExhausted func(bucket string, c Conn)
+}
+
+// Quotas enforces per-bucket byte budgets across many conns. Conns are subject
+// to Quotas by wrapping them WithQuotas. Traffic in both directions counts
+// towards the budget, which is checked on every Write.
+type Quotas struct {
+	cfg       QuotaConfig
+	limits    map[string]int64
+	budgets   map[string]*budget
+	live      map[string]int
+	lastSweep mtime.Instant
+	mx        sync.Mutex
+}
+
+type budget struct {
+	used        int64
+	periodStart mtime.Instant
+	exhausted   bool
+}
+
+// NewQuotas constructs Quotas with the given configuration.
+func NewQuotas(cfg QuotaConfig) *Quotas {
+	if cfg.Bucket == nil {
+		cfg.Bucket = ByID
+	}
+	return &Quotas{
+		cfg:     cfg,
+		limits:  make(map[string]int64),
+		budgets: make(map[string]*budget),
+		live:    make(map[string]int),
+	}
+}
+
+// SetLimit overrides the limit per period for the given bucket. Zero means
+// unlimited. Raising the limit of an exhausted bucket above what it has used
+// lifts the exhaustion, so OnExhausted is called again if it exhausts the new
+// limit.
+func (q *Quotas) SetLimit(bucket string, limit int64) {
+	q.mx.Lock()
+	q.limits[bucket] = limit
+	if b := q.budget(bucket); b != nil && (limit == 0 || b.used < limit) {
+		b.exhausted = false
+	}
+	q.mx.Unlock()
+}
+
+// Used returns the number of bytes that the given bucket has used in the
+// current period.
+func (q *Quotas) Used(bucket string) int64 {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	b := q.budget(bucket)
+	if b == nil {
+		return 0
+	}
+	return b.used
+}
+
+// Reset resets the budget of the given bucket, starting a new period.
+func (q *Quotas) Reset(bucket string) {
+	q.mx.Lock()
+	delete(q.budgets, bucket)
+	q.mx.Unlock()
+}
+
+// budget returns the current budget for bucket, rolling it over if its period
+// has elapsed. q.mx must be held.
+func (q *Quotas) budget(bucket string) *budget {
+	b := q.budgets[bucket]
+	if b != nil && q.expired(b) {
+		delete(q.budgets, bucket)
+		b = nil
+	}
+	return b
+}
+
+// expired checks whether the period of b has elapsed.
+func (q *Quotas) expired(b *budget) bool {
+	return q.cfg.Period > 0 && now().Sub(b.periodStart) >= q.cfg.Period
+}
+
+// attach registers an open conn counting towards bucket.
+func (q *Quotas) attach(bucket string) {
+	q.mx.Lock()
+	q.live[bucket]++
+	q.mx.Unlock()
+}
+
+// release unregisters a finished conn from bucket, forgetting the bucket's
+// budget if it has no more open conns and either never rolls over or its
+// period has elapsed.
+func (q *Quotas) release(bucket string) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.live[bucket]--
+	if q.live[bucket] > 0 {
+		return
+	}
+	delete(q.live, bucket)
+	if b := q.budgets[bucket]; b != nil && (q.cfg.Period <= 0 || q.expired(b)) {
+		delete(q.budgets, bucket)
+	}
+}
+
+// sweep forgets the expired budgets of buckets without open conns, at most
+// once per period. q.mx must be held.
+func (q *Quotas) sweep() {
+	if q.cfg.Period <= 0 || now().Sub(q.lastSweep) < q.cfg.Period {
+		return
+	}
+	q.lastSweep = now()
+	for bucket, b := range q.budgets {
+		if q.live[bucket] == 0 && q.expired(b) {
+			delete(q.budgets, bucket)
+		}
+	}
+}
+
+func (q *Quotas) limit(bucket string) int64 {
+	if limit, found := q.limits[bucket]; found {
+		return limit
+	}
+	return q.cfg.Limit
+}
+
+// consume counts n bytes against bucket and reports the conn if that exhausted
+// the budget.
+func (q *Quotas) consume(bucket string, c Conn, n int) {
+	if n == 0 {
+		return
+	}
+	q.mx.Lock()
+	q.sweep()
+	b := q.budget(bucket)
+	if b == nil {
+		b = &budget{periodStart: now()}
+		q.budgets[bucket] = b
+	}
+	b.used += int64(n)
+	limit := q.limit(bucket)
+	justExhausted := !b.exhausted && limit > 0 && b.used >= limit
+	if justExhausted {
+		b.exhausted = true
+	}
+	q.mx.Unlock()
+	if justExhausted && q.cfg.OnExhausted != nil {
+		runCallback(func(c Conn) { q.cfg.OnExhausted(bucket, c) }, c)
+	}
+}
+
+func (q *Quotas) exhausted(bucket string) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	b := q.budget(bucket)
+	return b != nil && b.exhausted
+}
+
+// quota applies Quotas to a single conn.
+type quota struct {
+	quotas *Quotas
+	bucket string
+}
+
+// beforeWrite enforces the quota policy before writing n bytes, returning an
+// error if the write must not proceed.
+func (qt *quota) beforeWrite(c *conn, n int) error {
+	if !qt.quotas.exhausted(qt.bucket) {
+		return nil
+	}
+	switch qt.quotas.cfg.Policy {
+	case QuotaThrottle:
+		if rate := qt.quotas.cfg.ThrottleRate; rate > 0 {
+			delay := time.Duration(n) * time.Second / time.Duration(rate)
+			time.Sleep(delay)
+			c.addThrottled(delay)
+		}
+		return nil
+	default:
+		c.Close()
+		return ErrQuotaExceeded
+	}
+}
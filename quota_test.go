@@ -0,0 +1,144 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaClose(t *testing.T) {
+	var exhausted []string
+	q := NewQuotas(QuotaConfig{
+		Limit: 100,
+		OnExhausted: func(bucket string, c Conn) {
+			exhausted = append(exhausted, bucket)
+		},
+	})
+	q.SetLimit("big", 1000)
+
+	conn := Wrap(&addrConn{}, time.Second, nil, WithID("user"), WithQuotas(q))
+	other := Wrap(&addrConn{}, time.Second, nil, WithID("user"), WithQuotas(q))
+	big := Wrap(&addrConn{}, time.Second, nil, WithID("big"), WithQuotas(q))
+	defer big.Close()
+
+	_, err := conn.Write(make([]byte, 60))
+	assert.NoError(t, err)
+	conn.Read(make([]byte, 50))
+	assert.Equal(t, int64(110), q.Used("user"))
+	assert.Equal(t, []string{"user"}, exhausted)
+
+	_, err = conn.Write(make([]byte, 10))
+	assert.Equal(t, ErrQuotaExceeded, err)
+	_, err = other.Write(make([]byte, 10))
+	assert.Equal(t, ErrQuotaExceeded, err, "quota should be shared by bucket")
+	assert.Equal(t, ClosedLocally, conn.Stats().ClosedBy)
+
+	_, err = big.Write(make([]byte, 500))
+	assert.NoError(t, err)
+	assert.Len(t, exhausted, 1)
+
+	q.Reset("user")
+	assert.Equal(t, int64(0), q.Used("user"))
+}
+
+func TestQuotaThrottle(t *testing.T) {
+	q := NewQuotas(QuotaConfig{Limit: 10, Policy: QuotaThrottle, ThrottleRate: 1000, Period: 200 * time.Millisecond})
+	conn := Wrap(&addrConn{}, time.Second, nil, WithQuotas(q))
+	defer conn.Close()
+
+	conn.Write(make([]byte, 50))
+	start := time.Now()
+	_, err := conn.Write(make([]byte, 50))
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond, "write should have been throttled")
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int64(0), q.Used(ID(conn)), "budget should roll over")
+	start = time.Now()
+	conn.Write(make([]byte, 5))
+	assert.True(t, time.Since(start) < 50*time.Millisecond, "write should not be throttled after roll over")
+}
+
+func TestQuotaUnlimited(t *testing.T) {
+	exhausted := false
+	q := NewQuotas(QuotaConfig{OnExhausted: func(string, Conn) { exhausted = true }})
+	conn := Wrap(&addrConn{}, time.Second, nil, WithQuotas(q))
+	defer conn.Close()
+	_, err := conn.Write(make([]byte, 1000))
+	assert.NoError(t, err)
+	_, err = conn.Write(make([]byte, 1000))
+	assert.NoError(t, err, "zero limit should mean unlimited")
+	assert.False(t, exhausted)
+	assert.Equal(t, int64(2000), q.Used(ID(conn)))
+}
+
+func TestQuotaForgetsFinishedBuckets(t *testing.T) {
+	q := NewQuotas(QuotaConfig{Limit: 1000})
+	for i := 0; i < 100; i++ {
+		conn := Wrap(&addrConn{}, time.Millisecond, nil, WithQuotas(q))
+		conn.Write(make([]byte, 10))
+		conn.Close()
+	}
+	shared := Wrap(&addrConn{}, time.Millisecond, nil, WithID("shared"), WithQuotas(q))
+	defer shared.Close()
+	shared.Write(make([]byte, 10))
+	time.Sleep(50 * time.Millisecond)
+	q.mx.Lock()
+	budgets := len(q.budgets)
+	q.mx.Unlock()
+	assert.Equal(t, 1, budgets, "budgets of buckets without open conns should be forgotten")
+	assert.Equal(t, int64(10), q.Used("shared"))
+
+	periodic := NewQuotas(QuotaConfig{Limit: 1000, Period: 20 * time.Millisecond})
+	conn := Wrap(&addrConn{}, time.Millisecond, nil, WithID("returning"), WithQuotas(periodic))
+	conn.Write(make([]byte, 10))
+	conn.Close()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(10), periodic.Used("returning"), "unexpired budget should survive reconnects")
+	time.Sleep(20 * time.Millisecond)
+	other := Wrap(&addrConn{}, time.Millisecond, nil, WithID("other"), WithQuotas(periodic))
+	defer other.Close()
+	other.Write(make([]byte, 10))
+	periodic.mx.Lock()
+	_, found := periodic.budgets["returning"]
+	periodic.mx.Unlock()
+	assert.False(t, found, "expired budgets should be swept")
+}
+
+func TestQuotaOnExhaustedPanic(t *testing.T) {
+	q := NewQuotas(QuotaConfig{
+		Limit: 10,
+		OnExhausted: func(bucket string, c Conn) {
+			panic("exhausted")
+		},
+	})
+	conn := Wrap(&addrConn{}, time.Second, nil, WithQuotas(q))
+	defer conn.Close()
+	assert.NotPanics(t, func() { conn.Write(make([]byte, 20)) })
+	_, err := conn.Write(make([]byte, 1))
+	assert.Equal(t, ErrQuotaExceeded, err)
+}
+
+func TestQuotaRaiseLimit(t *testing.T) {
+	var exhausted int
+	q := NewQuotas(QuotaConfig{
+		Limit: 10,
+		OnExhausted: func(bucket string, c Conn) {
+			exhausted++
+		},
+	})
+	conn := Wrap(&addrConn{}, time.Second, nil, WithID("user"), WithQuotas(q))
+	defer conn.Close()
+	conn.Write(make([]byte, 20))
+	_, err := conn.Write(make([]byte, 1))
+	assert.Equal(t, ErrQuotaExceeded, err)
+
+	other := Wrap(&addrConn{}, time.Second, nil, WithID("user"), WithQuotas(q))
+	defer other.Close()
+	q.SetLimit("user", 30)
+	_, err = other.Write(make([]byte, 5))
+	assert.NoError(t, err, "raising the limit should lift the exhaustion")
+	other.Write(make([]byte, 10))
+	assert.Equal(t, 2, exhausted, "exhausting the new limit should be reported")
+}
@@ -1,7 +1,9 @@
 package measured
 
 import (
+	"math"
 	"sync"
+	"time"
 
 	"github.com/getlantern/mtime"
 )
@@ -25,6 +27,21 @@ type rater struct {
 	lastSnapshotted  mtime.Instant
 	min              float64
 	max              float64
+	current          float64
+	ops              int
+	latencyTotal     time.Duration
+	latencyMax       time.Duration
+	sizes            SizeHistogram
+	halfLife         time.Duration
+	ewma             float64
+	recent           [bandwidthFilterLen]float64
+	recentIdx        int
+	rates            int
+	rateMean         float64
+	rateM2           float64
+	lastOpEnd        mtime.Instant
+	lastGap          time.Duration
+	jitter           float64
 	mx               sync.Mutex
 }
 
@@ -45,6 +62,40 @@ func (r *rater) advance(n int, ts mtime.Instant) {
 	r.mx.Unlock()
 }
 
+// op records an operation that transferred n bytes and ran from start until
+// end, advancing the count as of end.
+func (r *rater) op(n int, start mtime.Instant, end mtime.Instant) {
+	latency := end.Sub(start)
+	r.mx.Lock()
+	r.total += n
+	r.end = end
+	r.ops++
+	if n > 0 {
+		r.sizes[sizeBucket(n)]++
+	}
+	r.latencyTotal += latency
+	if latency > r.latencyMax {
+		r.latencyMax = latency
+	}
+	if n > 0 {
+		// Inter-arrival jitter as in RFC 3550, the smoothed mean deviation of
+		// the gaps between operations
+		if r.lastOpEnd != 0 {
+			gap := end.Sub(r.lastOpEnd)
+			if r.lastGap != 0 {
+				d := float64(gap - r.lastGap)
+				if d < 0 {
+					d = -d
+				}
+				r.jitter += (d - r.jitter) / 16
+			}
+			r.lastGap = gap
+		}
+		r.lastOpEnd = end
+	}
+	r.mx.Unlock()
+}
+
 // calc recalculates the internal EMA rate and updates the min/max accordingly.
 func (r *rater) calc() {
 	r.mx.Lock()
@@ -74,6 +125,24 @@ func (r *rater) calc() {
 	if !hasSnapshotted || newRate > r.max {
 		r.max = newRate
 	}
+	r.current = newRate
+	// Welford's online variance of the interval rates
+	r.rates++
+	meanDelta := newRate - r.rateMean
+	r.rateMean += meanDelta / float64(r.rates)
+	r.rateM2 += meanDelta * (newRate - r.rateMean)
+	r.recent[r.recentIdx] = newRate
+	r.recentIdx = (r.recentIdx + 1) % bandwidthFilterLen
+	if r.halfLife > 0 {
+		if !hasSnapshotted {
+			r.ewma = newRate
+		} else {
+			// Weight the new rate by how much time it covers relative to the
+			// half-life
+			alpha := 1 - math.Exp2(-deltaSeconds/r.halfLife.Seconds())
+			r.ewma += alpha * (newRate - r.ewma)
+		}
+	}
 	r.snapshottedTotal = r.total
 	r.lastSnapshotted = r.end
 
@@ -84,13 +153,94 @@ func (r *rater) calc() {
 // over the duration of this rater.
 func (r *rater) get() (total int, min float64, max float64, average float64) {
 	r.mx.Lock()
-	defer r.mx.Unlock()
-	total = r.total
-	min = r.min
-	max = r.max
+	s := r.snapshotLocked()
+	r.mx.Unlock()
+	return s.total, s.min, s.max, s.average
+}
+
+// raterSnapshot is a copy of all of a rater's values at a single point in
+// time.
+type raterSnapshot struct {
+	total      int
+	min        float64
+	max        float64
+	average    float64
+	ewma       float64
+	ops        int
+	avgLatency time.Duration
+	maxLatency time.Duration
+	sizes      SizeHistogram
+	stddev     float64
+	jitter     time.Duration
+}
+
+// snapshotLocked returns a snapshot of the rater. r.mx must be held.
+func (r *rater) snapshotLocked() raterSnapshot {
+	s := raterSnapshot{
+		total:      r.total,
+		min:        r.min,
+		max:        r.max,
+		ewma:       r.ewma,
+		ops:        r.ops,
+		maxLatency: r.latencyMax,
+		sizes:      r.sizes,
+		jitter:     time.Duration(r.jitter),
+	}
+	if r.rates > 1 {
+		s.stddev = math.Sqrt(r.rateM2 / float64(r.rates-1))
+	}
 	deltaSeconds := r.end.Sub(r.start).Seconds()
 	if deltaSeconds > 0 {
-		average = float64(total) / deltaSeconds
+		s.average = float64(r.total) / deltaSeconds
 	}
+	if r.ops > 0 {
+		s.avgLatency = r.latencyTotal / time.Duration(r.ops)
+	}
+	return s
+}
+
+// snapshotBoth returns snapshots of sent and recv taken while holding both of
+// their locks, so that they reflect the same point in time. It's the only place
+// that holds both locks, which are always acquired sent first.
+func snapshotBoth(sent *rater, recv *rater) (sentSnapshot raterSnapshot, recvSnapshot raterSnapshot) {
+	sent.mx.Lock()
+	recv.mx.Lock()
+	sentSnapshot = sent.snapshotLocked()
+	recvSnapshot = recv.snapshotLocked()
+	recv.mx.Unlock()
+	sent.mx.Unlock()
 	return
 }
+
+// lastActive returns the time of the most recent call to advance(), or 0 if
+// there hasn't been one.
+func (r *rater) lastActive() mtime.Instant {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.end
+}
+
+// rate returns the rate as of the most recent calc().
+func (r *rater) rate() float64 {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.current
+}
+
+// bandwidthFilterLen is the number of recent rate intervals considered when
+// estimating bandwidth.
+const bandwidthFilterLen = 10
+
+// estimatedBandwidth returns the maximum rate over the most recent
+// bandwidthFilterLen intervals in which there was activity.
+func (r *rater) estimatedBandwidth() float64 {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	max := float64(0)
+	for _, rate := range r.recent {
+		if rate > max {
+			max = rate
+		}
+	}
+	return max
+}
@@ -71,3 +71,127 @@ func TestRater(t *testing.T) {
 	assert.EqualValues(t, 2, max)
 	assert.EqualValues(t, 5.0/6.0, average)
 }
+
+func TestRaterEWMA(t *testing.T) {
+	r := &rater{halfLife: 1 * time.Second}
+	ts := mtime.Now()
+	r.begin(func() mtime.Instant {
+		return ts
+	})
+
+	ts = ts.Add(1 * time.Second)
+	r.advance(100, ts)
+	r.calc()
+	assert.EqualValues(t, 100, r.ewma, "first rate should seed the EWMA")
+
+	// One half-life at a rate of 0 should halve the EWMA
+	ts = ts.Add(1 * time.Second)
+	r.advance(0, ts)
+	r.calc()
+	assert.InDelta(t, 50, r.ewma, 0.0001)
+
+	// Two half-lives at a rate of 200 should move 3/4 of the way there
+	ts = ts.Add(2 * time.Second)
+	r.advance(400, ts)
+	r.calc()
+	assert.InDelta(t, 162.5, r.ewma, 0.0001)
+
+	noEWMA := &rater{}
+	noEWMA.begin(mtime.Now)
+	noEWMA.advance(100, mtime.Now().Add(time.Second))
+	noEWMA.calc()
+	assert.EqualValues(t, 0, noEWMA.ewma)
+}
+
+func TestEstimatedBandwidth(t *testing.T) {
+	r := &rater{}
+	ts := mtime.Now()
+	r.begin(func() mtime.Instant {
+		return ts
+	})
+	assert.EqualValues(t, 0, r.estimatedBandwidth())
+
+	// One fast interval followed by slow ones
+	ts = ts.Add(time.Second)
+	r.advance(1000, ts)
+	r.calc()
+	for i := 0; i < bandwidthFilterLen-1; i++ {
+		ts = ts.Add(time.Second)
+		r.advance(10, ts)
+		r.calc()
+	}
+	assert.EqualValues(t, 1000, r.estimatedBandwidth(), "peak should be retained within the filter window")
+
+	ts = ts.Add(time.Second)
+	r.advance(10, ts)
+	r.calc()
+	assert.EqualValues(t, 10, r.estimatedBandwidth(), "peak should expire after the filter window")
+
+	// Idle periods don't count towards the window
+	ts = ts.Add(time.Hour)
+	r.calc()
+	assert.EqualValues(t, 10, r.estimatedBandwidth())
+}
+
+func TestConnEstimatedBandwidth(t *testing.T) {
+	conn := Wrap(&addrConn{}, 5*time.Millisecond, nil)
+	defer conn.Close()
+	conn.Write(make([]byte, 1000))
+	time.Sleep(30 * time.Millisecond)
+	sent, recv := EstimatedBandwidth(&decoratedConn{conn})
+	assert.True(t, sent > 0, "should estimate from the interval with traffic")
+	assert.EqualValues(t, 0, recv)
+	sent, _ = EstimatedBandwidth(&addrConn{})
+	assert.EqualValues(t, 0, sent, "unmeasured conns have no bandwidth estimate")
+}
+
+func TestRateStddev(t *testing.T) {
+	r := &rater{}
+	ts := mtime.Now()
+	r.begin(func() mtime.Instant {
+		return ts
+	})
+	for _, n := range []int{2, 4, 4, 4, 5, 5, 7, 9} {
+		ts = ts.Add(time.Second)
+		r.advance(n, ts)
+		r.calc()
+	}
+	r.mx.Lock()
+	s := r.snapshotLocked()
+	r.mx.Unlock()
+	assert.InDelta(t, 2.138, s.stddev, 0.001)
+}
+
+func TestJitter(t *testing.T) {
+	r := &rater{}
+	ts := mtime.Now()
+	// Evenly spaced operations have no jitter
+	for i := 0; i < 5; i++ {
+		ts = ts.Add(10 * time.Millisecond)
+		r.op(1, ts, ts)
+	}
+	r.mx.Lock()
+	assert.EqualValues(t, 0, r.snapshotLocked().jitter)
+	r.mx.Unlock()
+
+	// Alternating gaps
+	for i := 0; i < 100; i++ {
+		gap := 10 * time.Millisecond
+		if i%2 == 0 {
+			gap = 30 * time.Millisecond
+		}
+		ts = ts.Add(gap)
+		r.op(1, ts, ts)
+	}
+	r.mx.Lock()
+	jitter := r.snapshotLocked().jitter
+	r.mx.Unlock()
+	assert.InDelta(t, float64(20*time.Millisecond), float64(jitter), float64(time.Millisecond))
+
+	// Empty operations are ignored
+	ts = ts.Add(time.Hour)
+	r.op(0, ts, ts)
+	r.mx.Lock()
+	assert.Equal(t, jitter, r.snapshotLocked().jitter)
+	r.mx.Unlock()
+}
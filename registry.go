@@ -0,0 +1,172 @@
+package measured
+
+import (
+	"sync"
+)
+
+// registry keeps track of all live measured conns as well as the totals of
+// conns that have already finished.
+type registry struct {
+	conns        map[*conn]bool
+	finishedSent int
+	finishedRecv int
+	errors       map[string]int
+	// byID accumulates the totals of finished conns by ID, but only while
+	// trackIDs is set and only for IDs set WithID or WithIDFunc
+	byID     map[string]*Totals
+	trackIDs bool
+	dials    dialCounters
+	// heavyHitters are the HeavyHitters trackers used by the registry's conns
+	heavyHitters map[*HeavyHitters]bool
+	mx           sync.RWMutex
+}
+
+// AggregateStats provides statistics across all measured conns.
+type AggregateStats struct {
+	// OpenConns is the number of conns that are currently open
+	OpenConns int
+	// SentTotal and RecvTotal include both open and finished conns
+	SentTotal int
+	RecvTotal int
+}
+
+// reg is the registry of the default instance, used by conns that aren't
+// wrapped WithInstance.
+var reg = newRegistry()
+
+func newRegistry() *registry {
+	return &registry{conns: make(map[*conn]bool), errors: make(map[string]int), byID: make(map[string]*Totals), heavyHitters: make(map[*HeavyHitters]bool)}
+}
+
+func (r *registry) add(c *conn) {
+	r.mx.Lock()
+	r.conns[c] = true
+	r.mx.Unlock()
+}
+
+func (r *registry) remove(c *conn) {
+	sent, _, _, _ := c.sent.get()
+	recv, _, _, _ := c.recv.get()
+	r.mx.Lock()
+	delete(r.conns, c)
+	r.finishedSent += sent
+	r.finishedRecv += recv
+	if r.trackIDs && c.explicitID {
+		r.addByID(c.id, sent, recv)
+	}
+	r.mx.Unlock()
+}
+
+func (r *registry) countError(err error) {
+	class := errorClass(err)
+	r.mx.Lock()
+	r.errors[class]++
+	r.mx.Unlock()
+}
+
+// errorCounts returns the number of unexpected errors encountered so far by
+// class.
+func (r *registry) errorCounts() map[string]int {
+	r.mx.RLock()
+	counts := make(map[string]int, len(r.errors))
+	for class, count := range r.errors {
+		counts[class] = count
+	}
+	r.mx.RUnlock()
+	return counts
+}
+
+// live returns a snapshot of the currently live conns.
+func (r *registry) live() []*conn {
+	r.mx.RLock()
+	conns := make([]*conn, 0, len(r.conns))
+	for c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mx.RUnlock()
+	return conns
+}
+
+// addHeavyHitters registers a HeavyHitters tracker used by one of the
+// registry's conns.
+func (r *registry) addHeavyHitters(h *HeavyHitters) {
+	r.mx.Lock()
+	r.heavyHitters[h] = true
+	r.mx.Unlock()
+}
+
+// topHeavyHitters returns up to n of the top buckets across all HeavyHitters
+// trackers used by the registry's conns, forgetting trackers that were stopped.
+func (r *registry) topHeavyHitters(n int) []HeavyHitter {
+	r.mx.Lock()
+	trackers := make([]*HeavyHitters, 0, len(r.heavyHitters))
+	for h := range r.heavyHitters {
+		if h.stopped() {
+			delete(r.heavyHitters, h)
+			continue
+		}
+		trackers = append(trackers, h)
+	}
+	r.mx.Unlock()
+	var top []HeavyHitter
+	for _, h := range trackers {
+		top = append(top, h.Top(n)...)
+	}
+	return topN(top, n)
+}
+
+func (r *registry) aggregate() *AggregateStats {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	stats := &AggregateStats{
+		OpenConns: len(r.conns),
+		SentTotal: r.finishedSent,
+		RecvTotal: r.finishedRecv,
+	}
+	for c := range r.conns {
+		sent, _, _, _ := c.sent.get()
+		recv, _, _, _ := c.recv.get()
+		stats.SentTotal += sent
+		stats.RecvTotal += recv
+	}
+	return stats
+}
+
+// addByID adds to the totals of the given ID. r.mx must be held.
+func (r *registry) addByID(id string, sent int, recv int) {
+	t := r.byID[id]
+	if t == nil {
+		t = &Totals{}
+		r.byID[id] = t
+	}
+	t.Sent += sent
+	t.Recv += recv
+}
+
+// aggregateByID returns the totals by ID of both finished and live conns with
+// explicitly set IDs.
+func (r *registry) aggregateByID() map[string]Totals {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	result := make(map[string]Totals, len(r.byID))
+	for id, t := range r.byID {
+		result[id] = *t
+	}
+	for c := range r.conns {
+		if !c.explicitID {
+			continue
+		}
+		sent, _, _, _ := c.sent.get()
+		recv, _, _, _ := c.recv.get()
+		t := result[c.id]
+		t.Sent += sent
+		t.Recv += recv
+		result[c.id] = t
+	}
+	return result
+}
+
+// Aggregate returns statistics aggregated across all measured conns.
+func Aggregate() *AggregateStats {
+	return reg.aggregate()
+}
@@ -0,0 +1,131 @@
+package measured
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/getlantern/mtime"
+)
+
+// SessionTag is the tag under which conns belonging to a Session report the
+// Session's ID.
+const SessionTag = "session"
+
+// Session groups several physical conns that carry one logical session, for
+// example when a transport migrates a session to a new conn or races several
+// conns and keeps the winner. It reports the stats of each physical conn as
+// well as totals for the whole session.
+type Session struct {
+	id    string
+	start mtime.Instant
+	conns []Conn
+	final map[Conn]*Stats
+	mx    sync.Mutex
+}
+
+// SessionStats provides statistics about a Session.
+type SessionStats struct {
+	// ID is the ID of the Session
+	ID string
+	// Conns contains the Stats of every physical conn that was part of the
+	// session, in the order in which they were added
+	Conns []*Stats
+	// OpenConns is the number of physical conns that are currently open
+	OpenConns int
+	// SentTotal and RecvTotal include all physical conns, open and finished
+	SentTotal int
+	RecvTotal int
+	// SentAvg and RecvAvg are the average rates over the lifetime of the
+	// session, in bytes per second
+	SentAvg float64
+	RecvAvg float64
+	// Duration is how long it's been since the Session was created
+	Duration time.Duration
+}
+
+// NewSession creates a Session with the given ID.
+func NewSession(id string) *Session {
+	return &Session{id: id, start: now(), final: make(map[Conn]*Stats)}
+}
+
+// ID returns the ID of the Session.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Wrap is like the package-level Wrap, but adds the resulting Conn to the
+// Session.
+func (s *Session) Wrap(wrapped net.Conn, rateInterval time.Duration, onFinish func(Conn), options ...Option) Conn {
+	c := Wrap(wrapped, rateInterval, onFinish, options...)
+	s.Add(c)
+	return c
+}
+
+// Add adds an already measured conn to the Session and tags it with the
+// Session's ID under SessionTag. Adding a conn that has already finished
+// includes its final stats.
+func (s *Session) Add(c Conn) {
+	SetTag(c, SessionTag, s.id)
+	s.mx.Lock()
+	s.conns = append(s.conns, c)
+	s.mx.Unlock()
+	if mc, ok := c.(*conn); ok {
+		mc.rewrap(s.finished, &opts{})
+	}
+}
+
+func (s *Session) finished(c Conn) {
+	stats := c.Stats()
+	s.mx.Lock()
+	s.final[c] = stats
+	s.mx.Unlock()
+}
+
+// Stats gets the current stats of the Session.
+func (s *Session) Stats() *SessionStats {
+	s.mx.Lock()
+	conns := make([]Conn, len(s.conns))
+	copy(conns, s.conns)
+	final := make([]*Stats, len(conns))
+	for i, c := range conns {
+		final[i] = s.final[c]
+	}
+	s.mx.Unlock()
+
+	stats := &SessionStats{ID: s.id, Conns: make([]*Stats, len(conns)), Duration: now().Sub(s.start)}
+	for i, c := range conns {
+		cs := final[i]
+		if cs == nil {
+			cs = c.Stats()
+			stats.OpenConns++
+		}
+		stats.Conns[i] = cs
+		stats.SentTotal += cs.SentTotal
+		stats.RecvTotal += cs.RecvTotal
+	}
+	if seconds := stats.Duration.Seconds(); seconds > 0 {
+		stats.SentAvg = float64(stats.SentTotal) / seconds
+		stats.RecvAvg = float64(stats.RecvTotal) / seconds
+	}
+	return stats
+}
+
+// Close closes all open physical conns of the Session.
+func (s *Session) Close() error {
+	s.mx.Lock()
+	var open []Conn
+	for _, c := range s.conns {
+		if s.final[c] == nil {
+			open = append(open, c)
+		}
+	}
+	s.mx.Unlock()
+	var firstErr error
+	for _, c := range open {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,45 @@
+package measured
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession(t *testing.T) {
+	s := NewSession("session-1")
+	assert.Equal(t, "session-1", s.ID())
+
+	first := s.Wrap(&addrConn{remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 443}}, time.Second, nil)
+	first.Write([]byte("12345"))
+	first.Read(make([]byte, 3))
+	assert.Equal(t, "session-1", first.Stats().Tags[SessionTag])
+
+	// migrate to a new conn
+	first.Close()
+	second := Wrap(&addrConn{remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 443}}, time.Second, nil, WithID("second"))
+	s.Add(second)
+	second.Write([]byte("1234567"))
+
+	time.Sleep(50 * time.Millisecond)
+	stats := s.Stats()
+	assert.Equal(t, "session-1", stats.ID)
+	if assert.Len(t, stats.Conns, 2) {
+		assert.Equal(t, 5, stats.Conns[0].SentTotal)
+		assert.Equal(t, "second", stats.Conns[1].ID)
+		assert.Equal(t, 7, stats.Conns[1].SentTotal)
+	}
+	assert.Equal(t, 1, stats.OpenConns)
+	assert.Equal(t, 12, stats.SentTotal)
+	assert.Equal(t, 3, stats.RecvTotal)
+	assert.True(t, stats.SentAvg > 0)
+	assert.True(t, stats.Duration >= 50*time.Millisecond)
+
+	assert.NoError(t, s.Close())
+	time.Sleep(50 * time.Millisecond)
+	stats = s.Stats()
+	assert.Equal(t, 0, stats.OpenConns)
+	assert.Equal(t, 12, stats.SentTotal, "finished conns should keep counting towards the session")
+}
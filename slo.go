@@ -0,0 +1,176 @@
+package measured
+
+import (
+	"sync"
+	"time"
+)
+
+// SLO configures the service levels checked by an SLOChecker. Zero values
+// disable the corresponding check.
+type SLO struct {
+	// MinThroughput is the minimum rate in bytes per second (sent plus
+	// received) that an active conn is expected to sustain. Intervals in which
+	// a conn doesn't transfer anything are not considered.
+	MinThroughput float64
+	// Sustain is the number of consecutive active intervals a conn has to stay
+	// below MinThroughput before a violation is reported. Defaults to 1.
+	Sustain int
+	// MaxErrorRate is the maximum number of unexpected errors per second
+	// across all measured conns.
+	MaxErrorRate float64
+}
+
+// ViolationKind identifies which part of an SLO was violated.
+type ViolationKind int
+
+const (
+	// LowThroughput means a conn sustained less than SLO.MinThroughput
+	LowThroughput ViolationKind = iota
+	// HighErrorRate means errors occurred faster than SLO.MaxErrorRate
+	HighErrorRate
+)
+
+func (k ViolationKind) String() string {
+	switch k {
+	case LowThroughput:
+		return "low throughput"
+	case HighErrorRate:
+		return "high error rate"
+	default:
+		return "unknown"
+	}
+}
+
+// Violation describes a single SLO violation.
+type Violation struct {
+	Kind ViolationKind
+	// Conn is the offending conn, or nil for aggregate violations
+	Conn Conn
+	// Value is the observed value and Threshold the configured limit
+	Value     float64
+	Threshold float64
+}
+
+// SLOChecker periodically evaluates all measured conns against an SLO and
+// calls an alert callback for every violation.
+type SLOChecker struct {
+	slo      SLO
+	interval time.Duration
+	alert    func(*Violation)
+	conns    map[*conn]*sloState
+	errors   int
+	stopCh   chan interface{}
+	stopOnce sync.Once
+	stopWg   sync.WaitGroup
+}
+
+// sloState tracks a single conn between evaluations.
+type sloState struct {
+	transferred int
+	below       int
+	alerted     bool
+}
+
+// NewSLOChecker constructs an SLOChecker that evaluates slo every interval.
+// A conn that keeps violating MinThroughput is only reported once until it
+// recovers, while HighErrorRate is reported for every interval exceeding it.
+// A zero or negative interval is replaced with DefaultReportInterval.
+func NewSLOChecker(slo SLO, interval time.Duration, alert func(*Violation)) *SLOChecker {
+	if slo.Sustain < 1 {
+		slo.Sustain = 1
+	}
+	interval = validReportInterval(interval)
+	s := &SLOChecker{
+		slo:      slo,
+		interval: interval,
+		alert:    alert,
+		conns:    make(map[*conn]*sloState),
+		errors:   totalErrors(),
+		stopCh:   make(chan interface{}),
+	}
+	s.stopWg.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *SLOChecker) run() {
+	defer s.stopWg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.check()
+		}
+	}
+}
+
+func (s *SLOChecker) check() {
+	seconds := s.interval.Seconds()
+
+	if s.slo.MinThroughput > 0 {
+		live := reg.live()
+		conns := make(map[*conn]*sloState, len(live))
+		for _, c := range live {
+			sent, _, _, _ := c.sent.get()
+			recv, _, _, _ := c.recv.get()
+			transferred := sent + recv
+			state := s.conns[c]
+			if state == nil {
+				// Traffic from before the first check didn't necessarily take a
+				// whole interval, so only measure from here on
+				conns[c] = &sloState{transferred: transferred}
+				continue
+			}
+			conns[c] = state
+			delta := transferred - state.transferred
+			state.transferred = transferred
+			if delta == 0 {
+				continue
+			}
+			rate := float64(delta) / seconds
+			if rate >= s.slo.MinThroughput {
+				state.below = 0
+				state.alerted = false
+				continue
+			}
+			state.below++
+			if state.below >= s.slo.Sustain && !state.alerted {
+				state.alerted = true
+				s.raise(&Violation{Kind: LowThroughput, Conn: c, Value: rate, Threshold: s.slo.MinThroughput})
+			}
+		}
+		// Forget conns that are no longer live
+		s.conns = conns
+	}
+
+	if s.slo.MaxErrorRate > 0 {
+		errors := totalErrors()
+		rate := float64(errors-s.errors) / seconds
+		s.errors = errors
+		if rate > s.slo.MaxErrorRate {
+			s.raise(&Violation{Kind: HighErrorRate, Value: rate, Threshold: s.slo.MaxErrorRate})
+		}
+	}
+}
+
+// Stop stops the SLOChecker. It's safe to call Stop more than once.
+func (s *SLOChecker) Stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	s.stopWg.Wait()
+}
+
+func totalErrors() int {
+	total := 0
+	for _, count := range reg.errorCounts() {
+		total += count
+	}
+	return total
+}
+
+// raise calls the alert callback, recovering from any panic in it.
+func (s *SLOChecker) raise(v *Violation) {
+	safely("SLO alert", func() { s.alert(v) })
+}
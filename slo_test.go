@@ -0,0 +1,113 @@
+package measured
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSLOCheckerThroughput(t *testing.T) {
+	var violations []*Violation
+	s := NewSLOChecker(SLO{MinThroughput: 100, Sustain: 2}, time.Minute, func(v *Violation) {
+		violations = append(violations, v)
+	})
+	defer s.Stop()
+
+	conn := Wrap(&addrConn{}, time.Minute, nil)
+	defer conn.Close()
+	forConn := func() []*Violation {
+		var result []*Violation
+		for _, v := range violations {
+			if v.Conn == conn {
+				result = append(result, v)
+			}
+		}
+		return result
+	}
+
+	s.check()
+	conn.Write(make([]byte, 600))
+	s.check()
+	assert.Empty(t, forConn(), "should wait for sustained violation")
+	conn.Write(make([]byte, 600))
+	s.check()
+	if assert.Len(t, forConn(), 1) {
+		v := forConn()[0]
+		assert.Equal(t, LowThroughput, v.Kind)
+		assert.Equal(t, float64(10), v.Value)
+		assert.Equal(t, float64(100), v.Threshold)
+	}
+	conn.Write(make([]byte, 600))
+	s.check()
+	assert.Len(t, forConn(), 1, "should not report again until recovered")
+
+	s.check()
+	assert.Len(t, forConn(), 1, "idle intervals should be ignored")
+}
+
+func TestSLOCheckerErrorRate(t *testing.T) {
+	var violations []*Violation
+	s := NewSLOChecker(SLO{MaxErrorRate: 0.01}, time.Minute, func(v *Violation) {
+		violations = append(violations, v)
+	})
+	defer s.Stop()
+
+	s.check()
+	assert.Empty(t, violations)
+
+	conn := Wrap(&errConn{err: errors.New("boom")}, time.Minute, nil)
+	defer conn.Close()
+	conn.Read(make([]byte, 10))
+	s.check()
+	if assert.Len(t, violations, 1) {
+		assert.Equal(t, HighErrorRate, violations[0].Kind)
+		assert.Nil(t, violations[0].Conn)
+		assert.Equal(t, "high error rate", violations[0].Kind.String())
+	}
+}
+
+func TestSLOCheckerAlertPanic(t *testing.T) {
+	s := NewSLOChecker(SLO{MaxErrorRate: 0.01}, time.Minute, func(v *Violation) {
+		panic("SLO alert")
+	})
+	defer s.Stop()
+
+	s.check()
+	conn := Wrap(&errConn{err: errors.New("boom")}, time.Minute, nil)
+	defer conn.Close()
+	conn.Read(make([]byte, 10))
+	assert.NotPanics(t, s.check)
+}
+
+func TestSLOCheckerInvalidInterval(t *testing.T) {
+	assert.NotPanics(t, func() {
+		NewSLOChecker(SLO{MinThroughput: 1}, -time.Second, func(*Violation) {}).Stop()
+	})
+}
+
+func TestSLOCheckerNewConn(t *testing.T) {
+	var violations []*Violation
+	conn := Wrap(&addrConn{}, time.Minute, nil)
+	defer conn.Close()
+	s := NewSLOChecker(SLO{MinThroughput: 100}, time.Minute, func(v *Violation) {
+		if v.Conn == conn {
+			violations = append(violations, v)
+		}
+	})
+	defer s.Stop()
+
+	conn.Write(make([]byte, 600))
+	s.check()
+	assert.Empty(t, violations, "traffic before the first check shouldn't be rated")
+	conn.Write(make([]byte, 600))
+	s.check()
+	assert.Len(t, violations, 1)
+}
+
+func TestSLOCheckerStopTwice(t *testing.T) {
+	s := NewSLOChecker(SLO{MinThroughput: 1}, time.Minute, func(*Violation) {})
+	s.Stop()
+	assert.NotPanics(t, s.Stop)
+}
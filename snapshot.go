@@ -0,0 +1,136 @@
+package measured
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// persistedState is the on-disk representation of the registry's aggregate
+// stats.
+type persistedState struct {
+	SentTotal int               `json:"sent_total"`
+	RecvTotal int               `json:"recv_total"`
+	Errors    map[string]int    `json:"errors"`
+	ByID      map[string]Totals `json:"by_id"`
+}
+
+// Snapshotter periodically saves the aggregate stats of all measured conns,
+// including totals by conn ID, to a JSON file, so that accumulated totals
+// survive process restarts. Totals by ID are only kept for conns whose ID was
+// set WithID or WithIDFunc, not for automatically assigned IDs. Since totals
+// are kept for every such ID, the set of IDs should be bounded, e.g. user or
+// device IDs.
+type Snapshotter struct {
+	path     string
+	onError  func(error)
+	saveMx   sync.Mutex
+	stopCh   chan interface{}
+	stopOnce sync.Once
+	stopWg   sync.WaitGroup
+}
+
+// StartSnapshots loads any previous snapshot from path into the aggregate stats
+// and then saves a snapshot to path every interval. Errors while saving
+// periodically are passed to onError if it's non-nil. A zero or negative
+// interval is replaced with DefaultReportInterval.
+//
+// Conns that were still open when the last snapshot was taken are loaded as
+// finished. StartSnapshots should only be called once, at startup.
+func StartSnapshots(path string, interval time.Duration, onError func(error)) (*Snapshotter, error) {
+	if err := loadSnapshot(path); err != nil {
+		return nil, err
+	}
+	s := &Snapshotter{
+		path:    path,
+		onError: onError,
+		stopCh:  make(chan interface{}),
+	}
+	s.stopWg.Add(1)
+	go s.run(validReportInterval(interval))
+	return s, nil
+}
+
+func loadSnapshot(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		b = nil
+	} else if err != nil {
+		return err
+	}
+	state := &persistedState{}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, state); err != nil {
+			return err
+		}
+	}
+
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+	reg.finishedSent += state.SentTotal
+	reg.finishedRecv += state.RecvTotal
+	for class, count := range state.Errors {
+		reg.errors[class] += count
+	}
+	for id, t := range state.ByID {
+		reg.addByID(id, t.Sent, t.Recv)
+	}
+	reg.trackIDs = true
+	return nil
+}
+
+func (s *Snapshotter) run(interval time.Duration) {
+	defer s.stopWg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil && s.onError != nil {
+				s.onError(err)
+			}
+		}
+	}
+}
+
+// Save saves a snapshot immediately. The file is replaced atomically, so an
+// interrupted save never corrupts the previous snapshot.
+func (s *Snapshotter) Save() error {
+	s.saveMx.Lock()
+	defer s.saveMx.Unlock()
+
+	agg := reg.aggregate()
+	state := &persistedState{
+		SentTotal: agg.SentTotal,
+		RecvTotal: agg.RecvTotal,
+		Errors:    reg.errorCounts(),
+		ByID:      reg.aggregateByID(),
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// ByID returns the accumulated totals by explicitly set conn ID, including
+// both loaded and live conns.
+func (s *Snapshotter) ByID() map[string]Totals {
+	return reg.aggregateByID()
+}
+
+// Stop stops periodic snapshotting and saves a final snapshot. It's safe to
+// call Stop more than once.
+func (s *Snapshotter) Stop() error {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	s.stopWg.Wait()
+	return s.Save()
+}
@@ -0,0 +1,98 @@
+package measured
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "measured")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+	// The registry is global, so use an ID and error class unique to this run
+	id := fmt.Sprintf("snapshot-user-%d", time.Now().UnixNano())
+	class := id
+	previous := &persistedState{
+		SentTotal: 100,
+		RecvTotal: 50,
+		Errors:    map[string]int{class: 3},
+		ByID:      map[string]Totals{id: {Sent: 100, Recv: 50}},
+	}
+	b, _ := json.Marshal(previous)
+	if !assert.NoError(t, ioutil.WriteFile(path, b, 0644)) {
+		return
+	}
+
+	before := Aggregate()
+	s, err := StartSnapshots(path, time.Hour, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	after := Aggregate()
+	assert.Equal(t, before.SentTotal+100, after.SentTotal)
+	assert.Equal(t, before.RecvTotal+50, after.RecvTotal)
+	assert.Equal(t, 3, reg.errorCounts()[class])
+
+	conn := Wrap(&addrConn{}, time.Second, nil, WithID(id))
+	conn.Write(make([]byte, 10))
+	assert.Equal(t, Totals{Sent: 110, Recv: 50}, s.ByID()[id], "should include live conns")
+	anonymous := Wrap(&addrConn{}, time.Second, nil)
+	anonymous.Write(make([]byte, 10))
+	assert.NotContains(t, s.ByID(), ID(anonymous), "should not include live conns with automatic IDs")
+	conn.Close()
+	anonymous.Close()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, Totals{Sent: 110, Recv: 50}, s.ByID()[id], "should include finished conns")
+	assert.NotContains(t, s.ByID(), ID(anonymous), "should not include finished conns with automatic IDs")
+
+	if !assert.NoError(t, s.Stop()) {
+		return
+	}
+	b, err = ioutil.ReadFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	saved := &persistedState{}
+	if !assert.NoError(t, json.Unmarshal(b, saved)) {
+		return
+	}
+	assert.Equal(t, Totals{Sent: 110, Recv: 50}, saved.ByID[id])
+	assert.Equal(t, 3, saved.Errors[class])
+	assert.True(t, saved.SentTotal >= 110)
+}
+
+func TestSnapshotsCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "measured")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+	ioutil.WriteFile(path, []byte("not json"), 0644)
+	_, err = StartSnapshots(path, time.Hour, nil)
+	assert.Error(t, err)
+}
+
+func TestSnapshotsStopTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "measured")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	s, err := StartSnapshots(filepath.Join(dir, "snapshot.json"), time.Hour, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, s.Stop())
+	assert.NoError(t, s.Stop(), "stopping again should just save again")
+}
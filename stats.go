@@ -0,0 +1,47 @@
+package measured
+
+// Delta returns the change in these Stats since prev, which should be an
+// earlier snapshot of the same conn. Cumulative counts (bytes, retransmits,
+// operations, timeouts, throttled time, size histograms), Phases and Duration
+// are relative to prev. All other fields, like rates, latencies, errors and
+// tags, describe the conn as of these Stats. If prev is nil, Delta returns a
+// copy of these Stats.
+func (s *Stats) Delta(prev *Stats) *Stats {
+	d := *s
+	if prev == nil {
+		return &d
+	}
+	d.SentTotal -= prev.SentTotal
+	d.RecvTotal -= prev.RecvTotal
+	d.SentWire -= prev.SentWire
+	d.RecvWire -= prev.RecvWire
+	d.Retransmits -= prev.Retransmits
+	d.Ops -= prev.Ops
+	d.OpErrors -= prev.OpErrors
+	for i := range d.OpLatencies {
+		d.OpLatencies[i] -= prev.OpLatencies[i]
+	}
+	d.Probes -= prev.Probes
+	d.ProbeErrors -= prev.ProbeErrors
+	d.ReadOps -= prev.ReadOps
+	d.WriteOps -= prev.WriteOps
+	d.ReadTimeouts -= prev.ReadTimeouts
+	d.WriteTimeouts -= prev.WriteTimeouts
+	d.DeadlineSets -= prev.DeadlineSets
+	d.ThrottledTime -= prev.ThrottledTime
+	d.SentOverhead -= prev.SentOverhead
+	d.RecvOverhead -= prev.RecvOverhead
+	d.PayloadEfficiency = payloadEfficiency(d.SentTotal, d.RecvTotal, d.SentOverhead, d.RecvOverhead)
+	for i := range d.SentSizes {
+		d.SentSizes[i] -= prev.SentSizes[i]
+		d.RecvSizes[i] -= prev.RecvSizes[i]
+	}
+	if len(prev.Phases) <= len(d.Phases) {
+		d.Phases = d.Phases[len(prev.Phases):]
+	}
+	if len(d.Phases) == 0 {
+		d.Phases = nil
+	}
+	d.Duration -= prev.Duration
+	return &d
+}
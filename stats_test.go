@@ -0,0 +1,70 @@
+package measured
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelta(t *testing.T) {
+	prev := &Stats{
+		SentTotal: 10,
+		RecvTotal: 20,
+		SentMax:   5,
+		SentSizes: SizeHistogram{1, 2, 0, 0},
+		Phases:    []Phase{{Name: "connect"}},
+		Duration:  1 * time.Second,
+	}
+	current := &Stats{
+		SentTotal: 15,
+		RecvTotal: 50,
+		SentMax:   7,
+		SentSizes: SizeHistogram{2, 2, 1, 0},
+		Phases:    []Phase{{Name: "connect"}, {Name: "transfer"}},
+		Tags:      map[string]string{"a": "b"},
+		Duration:  3 * time.Second,
+	}
+	delta := current.Delta(prev)
+	assert.Equal(t, 5, delta.SentTotal)
+	assert.Equal(t, 30, delta.RecvTotal)
+	assert.EqualValues(t, 7, delta.SentMax, "rates should not be diffed")
+	assert.Equal(t, SizeHistogram{1, 0, 1, 0}, delta.SentSizes)
+	assert.Equal(t, []Phase{{Name: "transfer"}}, delta.Phases)
+	assert.Equal(t, map[string]string{"a": "b"}, delta.Tags)
+	assert.Equal(t, 2*time.Second, delta.Duration)
+	assert.Equal(t, 15, current.SentTotal, "original should not be modified")
+
+	assert.Equal(t, current, current.Delta(nil))
+}
+
+func TestIntervalReport(t *testing.T) {
+	var mx sync.Mutex
+	var deltas []*Stats
+	finished := make(chan interface{})
+	conn := Wrap(&addrConn{}, 10*time.Millisecond, func(c Conn) {
+		close(finished)
+	}, WithIntervalReport(20*time.Millisecond, func(c Conn, delta *Stats) {
+		mx.Lock()
+		deltas = append(deltas, delta)
+		mx.Unlock()
+	}))
+	conn.Write(make([]byte, 10))
+	time.Sleep(50 * time.Millisecond)
+	conn.Write(make([]byte, 20))
+	conn.Close()
+	<-finished
+
+	mx.Lock()
+	defer mx.Unlock()
+	if !assert.True(t, len(deltas) >= 2, "should have a periodic and a final report") {
+		return
+	}
+	assert.Equal(t, 10, deltas[0].SentTotal)
+	total := 0
+	for _, delta := range deltas {
+		total += delta.SentTotal
+	}
+	assert.Equal(t, 30, total)
+}
@@ -0,0 +1,105 @@
+package measured
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// StatsView gives access to individual live statistics of a conn without
+// building a full Stats snapshot. Each accessor computes its value when called,
+// so values obtained from different calls may reflect different points in
+// time. Use Conn.Stats for a coherent snapshot.
+type StatsView struct {
+	c *conn
+}
+
+// ViewStats returns a StatsView of the measured conn that Unwrap finds in the
+// chain of conns starting at c. It's cheaper than Stats when only a few values
+// are needed. If there's no measured conn, ok is false.
+func ViewStats(c net.Conn) (view StatsView, ok bool) {
+	mc, ok := findConn(c)
+	if !ok {
+		return StatsView{}, false
+	}
+	return StatsView{mc}, true
+}
+
+// SentTotal returns the number of bytes sent so far.
+func (v StatsView) SentTotal() int {
+	if v.c.countersOnly {
+		return int(atomic.LoadInt64(&v.c.sentCount))
+	}
+	total, _, _, _ := v.c.sent.get()
+	return total
+}
+
+// RecvTotal returns the number of bytes received so far.
+func (v StatsView) RecvTotal() int {
+	if v.c.countersOnly {
+		return int(atomic.LoadInt64(&v.c.recvCount))
+	}
+	total, _, _, _ := v.c.recv.get()
+	return total
+}
+
+// SentMin returns the minimum send rate.
+func (v StatsView) SentMin() float64 {
+	_, min, _, _ := v.c.sent.get()
+	return min
+}
+
+// SentMax returns the maximum send rate.
+func (v StatsView) SentMax() float64 {
+	_, _, max, _ := v.c.sent.get()
+	return max
+}
+
+// SentAvg returns the average send rate.
+func (v StatsView) SentAvg() float64 {
+	_, _, _, avg := v.c.sent.get()
+	return avg
+}
+
+// RecvMin returns the minimum receive rate.
+func (v StatsView) RecvMin() float64 {
+	_, min, _, _ := v.c.recv.get()
+	return min
+}
+
+// RecvMax returns the maximum receive rate.
+func (v StatsView) RecvMax() float64 {
+	_, _, max, _ := v.c.recv.get()
+	return max
+}
+
+// RecvAvg returns the average receive rate.
+func (v StatsView) RecvAvg() float64 {
+	_, _, _, avg := v.c.recv.get()
+	return avg
+}
+
+// SentRate returns the send rate calculated at the most recent rate interval.
+func (v StatsView) SentRate() float64 {
+	return v.c.sent.rate()
+}
+
+// RecvRate returns the receive rate calculated at the most recent rate
+// interval.
+func (v StatsView) RecvRate() float64 {
+	return v.c.recv.rate()
+}
+
+// Duration returns how long it's been since the conn was wrapped.
+func (v StatsView) Duration() time.Duration {
+	return v.c.age()
+}
+
+// Tag returns the value of the tag k set on the conn and whether it was found.
+// Labels and ID tags aren't included.
+func (v StatsView) Tag(k string) (string, bool) {
+	v.c.metaMx.RLock()
+	value, found := v.c.tags[k]
+	v.c.metaMx.RUnlock()
+	return value, found
+}
@@ -0,0 +1,55 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsView(t *testing.T) {
+	conn := Wrap(&addrConn{}, 5*time.Millisecond, nil, WithTags(map[string]string{"protocol": "http"}))
+	defer conn.Close()
+	view, ok := ViewStats(conn)
+	if !assert.True(t, ok) {
+		return
+	}
+	conn.Write(make([]byte, 10))
+	conn.Read(make([]byte, 20))
+	time.Sleep(20 * time.Millisecond)
+
+	stats := conn.Stats()
+	assert.Equal(t, stats.SentTotal, view.SentTotal())
+	assert.Equal(t, stats.RecvTotal, view.RecvTotal())
+	assert.Equal(t, stats.SentMin, view.SentMin())
+	assert.Equal(t, stats.SentMax, view.SentMax())
+	assert.Equal(t, stats.SentAvg, view.SentAvg())
+	assert.Equal(t, stats.RecvMin, view.RecvMin())
+	assert.Equal(t, stats.RecvMax, view.RecvMax())
+	assert.Equal(t, stats.RecvAvg, view.RecvAvg())
+	assert.True(t, view.SentRate() >= 0)
+	assert.True(t, view.RecvRate() >= 0)
+	assert.True(t, view.Duration() >= stats.Duration)
+	protocol, found := view.Tag("protocol")
+	assert.True(t, found)
+	assert.Equal(t, "http", protocol)
+
+	counting := Wrap(&addrConn{}, time.Second, nil, WithCountersOnly())
+	defer counting.Close()
+	counting.Write(make([]byte, 7))
+	view, _ = ViewStats(counting)
+	assert.Equal(t, 7, view.SentTotal())
+
+	_, ok = ViewStats(&addrConn{})
+	assert.False(t, ok, "unmeasured conns have no view")
+}
+
+func TestStatsViewAllocations(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	allocs := testing.AllocsPerRun(100, func() {
+		view, _ := ViewStats(conn)
+		view.SentTotal()
+	})
+	assert.EqualValues(t, 0, allocs)
+}
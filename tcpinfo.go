@@ -0,0 +1,26 @@
+package measured
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// tcpInfo is the subset of TCP_INFO that we expose in Stats.
+type tcpInfo struct {
+	rtt         time.Duration
+	rttVar      time.Duration
+	retransmits int
+	cwnd        int
+}
+
+// findSyscallConn walks the chain of wrapped connections starting at c looking
+// for one that gives access to the raw socket.
+func findSyscallConn(c net.Conn) syscall.Conn {
+	for ; c != nil; c = unwrapOnce(c) {
+		if sc, ok := c.(syscall.Conn); ok {
+			return sc
+		}
+	}
+	return nil
+}
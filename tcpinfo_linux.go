@@ -0,0 +1,39 @@
+//go:build linux
+// +build linux
+
+package measured
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func sampleTCPInfo(sc syscall.Conn) (*tcpInfo, error) {
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var info syscall.TCPInfo
+	var sysErr error
+	err = raw.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofTCPInfo)
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			sysErr = errno
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sysErr != nil {
+		return nil, sysErr
+	}
+	return &tcpInfo{
+		rtt:         time.Duration(info.Rtt) * time.Microsecond,
+		rttVar:      time.Duration(info.Rttvar) * time.Microsecond,
+		retransmits: int(info.Total_retrans),
+		cwnd:        int(info.Snd_cwnd),
+	}, nil
+}
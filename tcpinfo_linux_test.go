@@ -0,0 +1,50 @@
+package measured
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCPInfo(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 10)
+		conn.Read(b)
+		conn.Write(b)
+	}()
+
+	rateInterval := 20 * time.Millisecond
+	wrapped, err := net.Dial("tcp", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	conn := Wrap(wrapped, rateInterval, nil, WithTCPInfo())
+	defer conn.Close()
+	_, err = conn.Write([]byte("1234567890"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	b := make([]byte, 10)
+	_, err = conn.Read(b)
+	if !assert.NoError(t, err) {
+		return
+	}
+	time.Sleep(3 * rateInterval)
+
+	stats := conn.Stats()
+	assert.True(t, stats.RTT > 0, "should have sampled RTT")
+	assert.True(t, stats.CongestionWindow > 0, "should have sampled congestion window")
+}
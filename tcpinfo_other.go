@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package measured
+
+import (
+	"errors"
+	"syscall"
+)
+
+func sampleTCPInfo(sc syscall.Conn) (*tcpInfo, error) {
+	return nil, errors.New("TCP_INFO is only supported on Linux")
+}
@@ -0,0 +1,95 @@
+// Package testconn provides net.Conns that simulate network conditions such
+// as limited bandwidth, latency, jitter and packet loss, for testing code that
+// measures or reacts to connection performance.
+package testconn
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultRetransmitDelay is the delay used for lost operations when
+// Profile.RetransmitDelay isn't set. It matches the minimum TCP retransmission
+// timeout on Linux.
+const DefaultRetransmitDelay = 200 * time.Millisecond
+
+// Profile describes the simulated network conditions. Zero values disable the
+// corresponding simulation.
+type Profile struct {
+	// Bandwidth limits reads and writes to the given number of bytes per
+	// second.
+	Bandwidth int
+	// Latency is added to every read and write.
+	Latency time.Duration
+	// Jitter adds a random delay of up to Jitter to every read and write.
+	Jitter time.Duration
+	// Loss is the probability (between 0 and 1) that an operation is lost and
+	// has to be retransmitted. As with TCP, no data is actually lost, but the
+	// operation is delayed by RetransmitDelay.
+	Loss float64
+	// RetransmitDelay is the extra delay for lost operations, defaults to
+	// DefaultRetransmitDelay.
+	RetransmitDelay time.Duration
+	// Seed seeds the random source used for jitter and loss, making runs
+	// repeatable.
+	Seed int64
+}
+
+type conn struct {
+	net.Conn
+	profile Profile
+	rnd     *rand.Rand
+	rndMx   sync.Mutex
+}
+
+// Wrap wraps the given conn so that its reads and writes are shaped according
+// to profile.
+func Wrap(wrapped net.Conn, profile Profile) net.Conn {
+	if profile.RetransmitDelay <= 0 {
+		profile.RetransmitDelay = DefaultRetransmitDelay
+	}
+	return &conn{
+		Conn:    wrapped,
+		profile: profile,
+		rnd:     rand.New(rand.NewSource(profile.Seed)),
+	}
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	time.Sleep(c.delay(len(b)))
+	return c.Conn.Write(b)
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	time.Sleep(c.delay(n))
+	return n, err
+}
+
+// Wrapped implements the interface used by measured to find underlying conns.
+func (c *conn) Wrapped() net.Conn {
+	return c.Conn
+}
+
+// delay calculates how long an operation transferring n bytes takes.
+func (c *conn) delay(n int) time.Duration {
+	p := c.profile
+	d := p.Latency
+	if p.Bandwidth > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(p.Bandwidth)
+	}
+	if p.Jitter <= 0 && p.Loss <= 0 {
+		return d
+	}
+	c.rndMx.Lock()
+	if p.Jitter > 0 {
+		d += time.Duration(c.rnd.Int63n(int64(p.Jitter) + 1))
+	}
+	if p.Loss > 0 && c.rnd.Float64() < p.Loss {
+		d += p.RetransmitDelay
+	}
+	c.rndMx.Unlock()
+	return d
+}
@@ -0,0 +1,62 @@
+package testconn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatency(t *testing.T) {
+	elapsed := timeWrite(t, Profile{Latency: 20 * time.Millisecond}, 10)
+	assert.True(t, elapsed >= 20*time.Millisecond, "elapsed %v", elapsed)
+}
+
+func TestBandwidth(t *testing.T) {
+	elapsed := timeWrite(t, Profile{Bandwidth: 10000}, 500)
+	assert.True(t, elapsed >= 50*time.Millisecond, "elapsed %v", elapsed)
+}
+
+func TestJitterAndLoss(t *testing.T) {
+	elapsed := timeWrite(t, Profile{Jitter: time.Millisecond, Loss: 1, RetransmitDelay: 30 * time.Millisecond}, 10)
+	assert.True(t, elapsed >= 30*time.Millisecond, "elapsed %v", elapsed)
+	assert.True(t, elapsed < time.Second, "elapsed %v", elapsed)
+
+	elapsed = timeWrite(t, Profile{Loss: 0.0001}, 10)
+	assert.True(t, elapsed < DefaultRetransmitDelay, "elapsed %v", elapsed)
+}
+
+func TestRead(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	conn := Wrap(a, Profile{Latency: 20 * time.Millisecond})
+	assert.Equal(t, a, conn.(interface{ Wrapped() net.Conn }).Wrapped())
+	go b.Write([]byte("hello"))
+	start := time.Now()
+	buf := make([]byte, 10)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
+
+func timeWrite(t *testing.T, profile Profile, n int) time.Duration {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		buf := make([]byte, n)
+		for {
+			if _, err := b.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	conn := Wrap(a, profile)
+	start := time.Now()
+	_, err := conn.Write(make([]byte, n))
+	assert.NoError(t, err)
+	return time.Since(start)
+}
@@ -0,0 +1,62 @@
+package measured
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type threshold struct {
+	bytes int64
+	fn    func(Conn)
+}
+
+// thresholds tracks the cumulative traffic on a conn and fires callbacks the
+// first time it crosses each configured threshold.
+type thresholds struct {
+	// transferred and next are accessed atomically and must stay 64-bit
+	// aligned.
+	transferred int64
+	next        int64
+	pending     []threshold
+	mx          sync.Mutex
+}
+
+func newThresholds(ts []threshold) *thresholds {
+	pending := make([]threshold, len(ts))
+	copy(pending, ts)
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].bytes < pending[j].bytes
+	})
+	return &thresholds{next: pending[0].bytes, pending: pending}
+}
+
+// advance adds n bytes to the cumulative traffic and fires any thresholds that
+// have been crossed.
+func (t *thresholds) advance(c Conn, n int) {
+	if n == 0 {
+		return
+	}
+	transferred := atomic.AddInt64(&t.transferred, int64(n))
+	if transferred < atomic.LoadInt64(&t.next) {
+		return
+	}
+
+	t.mx.Lock()
+	var crossed []threshold
+	for len(t.pending) > 0 && t.pending[0].bytes <= transferred {
+		crossed = append(crossed, t.pending[0])
+		t.pending = t.pending[1:]
+	}
+	next := int64(math.MaxInt64) // no more thresholds
+	if len(t.pending) > 0 {
+		next = t.pending[0].bytes
+	}
+	atomic.StoreInt64(&t.next, next)
+	t.mx.Unlock()
+
+	for _, th := range crossed {
+		th.fn(c)
+	}
+}
@@ -0,0 +1,28 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholds(t *testing.T) {
+	var crossed []string
+	conn := Wrap(&addrConn{}, time.Second, nil,
+		WithThreshold(100, func(c Conn) { crossed = append(crossed, "100") }),
+		WithThreshold(10, func(c Conn) { crossed = append(crossed, "10") }),
+		WithThreshold(20, func(c Conn) { crossed = append(crossed, "20") }))
+	defer conn.Close()
+
+	conn.Write(make([]byte, 5))
+	assert.Empty(t, crossed)
+	conn.Read(make([]byte, 5))
+	assert.Equal(t, []string{"10"}, crossed)
+	conn.Write(make([]byte, 50))
+	assert.Equal(t, []string{"10", "20"}, crossed, "thresholds should fire only once")
+	conn.Write(make([]byte, 100))
+	assert.Equal(t, []string{"10", "20", "100"}, crossed)
+	conn.Write(make([]byte, 100))
+	assert.Equal(t, []string{"10", "20", "100"}, crossed)
+}
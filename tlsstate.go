@@ -0,0 +1,22 @@
+package measured
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// tlsConn is implemented by *tls.Conn and compatible implementations.
+type tlsConn interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// findTLSConn walks the chain of wrapped connections starting at c looking for
+// a TLS connection.
+func findTLSConn(c net.Conn) tlsConn {
+	for ; c != nil; c = unwrapOnce(c) {
+		if tc, ok := c.(tlsConn); ok {
+			return tc
+		}
+	}
+	return nil
+}
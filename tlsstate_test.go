@@ -0,0 +1,97 @@
+package measured
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSResumed(t *testing.T) {
+	cert, err := selfSignedCert()
+	if !assert.NoError(t, err) {
+		return
+	}
+	l, err := tls.Listen("tcp", "localhost:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				b := make([]byte, 5)
+				conn.Read(b)
+				conn.Write(b)
+			}()
+		}
+	}()
+
+	clientConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(10),
+	}
+	dial := func() *Stats {
+		tlsConn, err := tls.Dial("tcp", l.Addr().String(), clientConfig)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		conn := Wrap(tlsConn, 10*time.Millisecond, nil)
+		conn.Write([]byte("hello"))
+		b := make([]byte, 5)
+		conn.Read(b)
+		conn.Close()
+		return conn.Stats()
+	}
+
+	stats := dial()
+	if assert.NotNil(t, stats) {
+		assert.True(t, stats.TLS)
+		assert.False(t, stats.TLSResumed)
+	}
+	stats = dial()
+	if assert.NotNil(t, stats) {
+		assert.True(t, stats.TLS)
+		assert.True(t, stats.TLSResumed)
+	}
+}
+
+func TestNotTLS(t *testing.T) {
+	conn := Wrap(&net.TCPConn{}, 10*time.Millisecond, nil)
+	defer conn.Close()
+	stats := conn.Stats()
+	assert.False(t, stats.TLS)
+	assert.False(t, stats.TLSResumed)
+}
+
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
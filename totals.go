@@ -0,0 +1,28 @@
+package measured
+
+import (
+	"sync/atomic"
+)
+
+var (
+	totalSent int64
+	totalRecv int64
+)
+
+// TotalSent returns the number of bytes sent across all measured conns since
+// the process started or ResetTotals was last called.
+func TotalSent() int64 {
+	return atomic.LoadInt64(&totalSent)
+}
+
+// TotalRecv returns the number of bytes received across all measured conns
+// since the process started or ResetTotals was last called.
+func TotalRecv() int64 {
+	return atomic.LoadInt64(&totalRecv)
+}
+
+// ResetTotals resets TotalSent and TotalRecv to 0 and returns their values
+// prior to the reset.
+func ResetTotals() (sent int64, recv int64) {
+	return atomic.SwapInt64(&totalSent, 0), atomic.SwapInt64(&totalRecv, 0)
+}
@@ -0,0 +1,27 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTotals(t *testing.T) {
+	ResetTotals()
+	conn1 := Wrap(&addrConn{}, time.Second, nil)
+	defer conn1.Close()
+	conn2 := Wrap(&addrConn{}, time.Second, nil)
+	defer conn2.Close()
+	conn1.Write(make([]byte, 10))
+	conn2.Write(make([]byte, 5))
+	conn2.Read(make([]byte, 7))
+
+	assert.EqualValues(t, 15, TotalSent())
+	assert.EqualValues(t, 7, TotalRecv())
+	sent, recv := ResetTotals()
+	assert.EqualValues(t, 15, sent)
+	assert.EqualValues(t, 7, recv)
+	assert.EqualValues(t, 0, TotalSent())
+	assert.EqualValues(t, 0, TotalRecv())
+}
@@ -0,0 +1,38 @@
+package measured
+
+import (
+	"net"
+)
+
+// Unwrap finds the measurement layer in a chain of wrapped conns, starting
+// with c itself and following Wrapped(), NetConn() and Unwrap() methods.
+func Unwrap(c net.Conn) (Conn, bool) {
+	for ; c != nil; c = unwrapOnce(c) {
+		if mc, ok := c.(*conn); ok {
+			return mc, true
+		}
+	}
+	return nil, false
+}
+
+// rewrap handles wrapping a conn that is already measured. Rather than
+// measuring (and counting) the same traffic twice, it reuses the existing
+// tracker, registering onFinish and merging in any tags.
+func (c *conn) rewrap(onFinish func(Conn), o *opts) Conn {
+	for k, v := range o.tags {
+		c.setTag(k, v)
+	}
+	if onFinish == nil {
+		return c
+	}
+	c.metaMx.Lock()
+	finished := c.finished
+	if !finished {
+		c.moreOnFinish = append(c.moreOnFinish, onFinish)
+	}
+	c.metaMx.Unlock()
+	if finished {
+		go runCallback(onFinish, c)
+	}
+	return c
+}
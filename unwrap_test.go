@@ -0,0 +1,47 @@
+package measured
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoubleWrap(t *testing.T) {
+	finished := make(chan string, 2)
+	conn := Wrap(&addrConn{}, time.Second, func(c Conn) { finished <- "first" }, WithTags(map[string]string{"a": "1"}))
+	again := Wrap(conn, time.Second, func(c Conn) { finished <- "second" }, WithTags(map[string]string{"b": "2"}))
+	assert.Equal(t, conn, again, "should reuse existing tracker")
+
+	again.Write(make([]byte, 10))
+	assert.Equal(t, 10, conn.Stats().SentTotal, "traffic should not be double counted")
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, conn.Stats().Tags)
+
+	conn.Close()
+	assert.Equal(t, "first", <-finished)
+	assert.Equal(t, "second", <-finished)
+
+	// Wrapping a finished conn calls onFinish right away
+	Wrap(conn, time.Second, func(c Conn) { finished <- "late" })
+	assert.Equal(t, "late", <-finished)
+}
+
+func TestUnwrapChain(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+
+	found, ok := Unwrap(&framingConn{conn})
+	assert.True(t, ok)
+	assert.Equal(t, conn, found)
+
+	found, ok = Unwrap(conn)
+	assert.True(t, ok)
+	assert.Equal(t, conn, found)
+
+	_, ok = Unwrap(&framingConn{&addrConn{}})
+	assert.False(t, ok)
+
+	_, ok = Unwrap(&net.TCPConn{})
+	assert.False(t, ok)
+}
@@ -0,0 +1,51 @@
+package measured
+
+import (
+	"net"
+)
+
+// SetValue attaches v to the measured Conn underlying c under the given key,
+// replacing any existing value. It returns false if c isn't (or doesn't wrap) a
+// measured Conn. Like context keys, keys should be of an unexported type to
+// avoid collisions between packages.
+func SetValue[T any](c net.Conn, key interface{}, v T) bool {
+	mc, ok := findConn(c)
+	if !ok {
+		return false
+	}
+	mc.metaMx.Lock()
+	if mc.values == nil {
+		mc.values = make(map[interface{}]interface{})
+	}
+	mc.values[key] = v
+	mc.metaMx.Unlock()
+	return true
+}
+
+// GetValue returns the value attached to the measured Conn underlying c under
+// the given key using SetValue. It returns false if there is no such value or
+// it isn't a T.
+func GetValue[T any](c net.Conn, key interface{}) (T, bool) {
+	var zero T
+	mc, ok := findConn(c)
+	if !ok {
+		return zero, false
+	}
+	mc.metaMx.RLock()
+	v, found := mc.values[key]
+	mc.metaMx.RUnlock()
+	if !found {
+		return zero, false
+	}
+	typed, ok := v.(T)
+	return typed, ok
+}
+
+func findConn(c net.Conn) (*conn, bool) {
+	mc, ok := Unwrap(c)
+	if !ok {
+		return nil, false
+	}
+	impl, ok := mc.(*conn)
+	return impl, ok
+}
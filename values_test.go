@@ -0,0 +1,40 @@
+package measured
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type valuesKey string
+
+type session struct {
+	user string
+}
+
+func TestValues(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil)
+	defer conn.Close()
+	wrapped := tls.Client(conn, &tls.Config{})
+
+	_, found := GetValue[*session](conn, valuesKey("session"))
+	assert.False(t, found)
+
+	assert.True(t, SetValue(wrapped, valuesKey("session"), &session{user: "abc"}))
+	s, found := GetValue[*session](conn, valuesKey("session"))
+	if assert.True(t, found) {
+		assert.Equal(t, "abc", s.user)
+	}
+	_, found = GetValue[string](conn, valuesKey("session"))
+	assert.False(t, found, "wrong type should not be found")
+
+	SetValue(conn, valuesKey("count"), 5)
+	count, _ := GetValue[int](wrapped, valuesKey("count"))
+	assert.Equal(t, 5, count)
+
+	assert.False(t, SetValue(&addrConn{}, valuesKey("session"), 1), "unmeasured conn should not take values")
+	_, found = GetValue[int](&addrConn{}, valuesKey("count"))
+	assert.False(t, found)
+}
@@ -0,0 +1,89 @@
+package measured
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/getlantern/mtime"
+)
+
+type windowBucket struct {
+	slot int64
+	sent int
+	recv int
+}
+
+// window is a ring buffer of per-resolution byte counts covering a sliding
+// window of recent time.
+type window struct {
+	resolution time.Duration
+	buckets    []windowBucket
+	mx         sync.Mutex
+}
+
+func newWindow(size time.Duration, resolution time.Duration) *window {
+	n := int(size / resolution)
+	if n < 1 {
+		n = 1
+	}
+	return &window{resolution: resolution, buckets: make([]windowBucket, n)}
+}
+
+func (w *window) slot(ts mtime.Instant) int64 {
+	return int64(time.Duration(ts) / w.resolution)
+}
+
+// add records sent and received bytes as of ts.
+func (w *window) add(ts mtime.Instant, sent int, recv int) {
+	slot := w.slot(ts)
+	w.mx.Lock()
+	b := &w.buckets[slot%int64(len(w.buckets))]
+	if b.slot != slot {
+		// Bucket holds data from an older revolution of the ring, reset it
+		b.slot = slot
+		b.sent = 0
+		b.recv = 0
+	}
+	b.sent += sent
+	b.recv += recv
+	w.mx.Unlock()
+}
+
+// rateOver returns the average send and receive rates in bytes per second over
+// the period d leading up to ts, rounded up to the window's resolution and
+// capped at the window size.
+func (w *window) rateOver(ts mtime.Instant, d time.Duration) (sent float64, recv float64) {
+	n := int64((d + w.resolution - 1) / w.resolution)
+	if n > int64(len(w.buckets)) {
+		n = int64(len(w.buckets))
+	}
+	if n < 1 {
+		return 0, 0
+	}
+	current := w.slot(ts)
+	var sentTotal, recvTotal int
+	w.mx.Lock()
+	for _, b := range w.buckets {
+		if b.slot > current-n && b.slot <= current {
+			sentTotal += b.sent
+			recvTotal += b.recv
+		}
+	}
+	w.mx.Unlock()
+	seconds := (time.Duration(n) * w.resolution).Seconds()
+	return float64(sentTotal) / seconds, float64(recvTotal) / seconds
+}
+
+// RateOver returns the average send and receive rates in bytes per second of
+// the measured Conn underlying c over the most recent period d, which is
+// rounded up to the resolution of the window configured using WithWindow and
+// capped at its size. Without WithWindow, or if c isn't (or doesn't wrap) a
+// measured Conn, it returns 0.
+func RateOver(c net.Conn, d time.Duration) (sent float64, recv float64) {
+	mc, ok := findConn(c)
+	if !ok || mc.window == nil {
+		return 0, 0
+	}
+	return mc.window.rateOver(now(), d)
+}
@@ -0,0 +1,58 @@
+package measured
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getlantern/mtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow(t *testing.T) {
+	w := newWindow(5*time.Second, 1*time.Second)
+	ts := mtime.Instant(100 * time.Second)
+	for i := 0; i < 10; i++ {
+		w.add(ts, 100*(i+1), 10)
+		ts = ts.Add(1 * time.Second)
+	}
+	ts = ts.Add(-1 * time.Second)
+
+	// Only the most recent 5 seconds are retained
+	sent, recv := w.rateOver(ts, 5*time.Second)
+	assert.EqualValues(t, (600+700+800+900+1000)/5, sent)
+	assert.EqualValues(t, 10, recv)
+
+	sent, _ = w.rateOver(ts, 2*time.Second)
+	assert.EqualValues(t, (900+1000)/2, sent)
+
+	// Partial periods are rounded up to the resolution
+	sent, _ = w.rateOver(ts, 1500*time.Millisecond)
+	assert.EqualValues(t, (900+1000)/2, sent)
+
+	// Periods longer than the window are capped
+	sent, _ = w.rateOver(ts, time.Minute)
+	assert.EqualValues(t, (600+700+800+900+1000)/5, sent)
+
+	// Old buckets don't count once time has moved on
+	sent, recv = w.rateOver(ts.Add(3*time.Second), 2*time.Second)
+	assert.EqualValues(t, 0, sent)
+	assert.EqualValues(t, 0, recv)
+}
+
+func TestRateOver(t *testing.T) {
+	conn := Wrap(&addrConn{}, time.Second, nil, WithWindow(10*time.Second, 100*time.Millisecond))
+	defer conn.Close()
+	conn.Write(make([]byte, 1000))
+	conn.Read(make([]byte, 500))
+	sent, recv := RateOver(&decoratedConn{conn}, time.Second)
+	assert.EqualValues(t, 1000, sent)
+	assert.EqualValues(t, 500, recv)
+
+	noWindow := Wrap(&addrConn{}, time.Second, nil)
+	defer noWindow.Close()
+	noWindow.Write(make([]byte, 1000))
+	sent, _ = RateOver(noWindow, time.Second)
+	assert.EqualValues(t, 0, sent)
+	sent, _ = RateOver(&addrConn{}, time.Second)
+	assert.EqualValues(t, 0, sent, "unmeasured conns have no rates")
+}
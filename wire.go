@@ -0,0 +1,71 @@
+package measured
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// wireConn counts the bytes that actually pass through a lower layer of a
+// connection stack, for example the raw TCP connection underneath a TLS or
+// buffered conn.
+type wireConn struct {
+	// sent and recv are accessed atomically and must stay 64-bit aligned.
+	sent int64
+	recv int64
+	net.Conn
+}
+
+// WrapWire wraps the raw connection at the bottom of a connection stack so that
+// a measured Conn wrapping a higher layer (e.g. a tls.Conn built on top of the
+// returned conn) can report wire bytes alongside application bytes. Measured
+// finds the wire counter by walking the chain of wrapped connections, so
+// every layer in between needs to expose its underlying conn via Wrapped() or
+// NetConn().
+func WrapWire(wrapped net.Conn) net.Conn {
+	return &wireConn{Conn: wrapped}
+}
+
+func (c *wireConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.sent, int64(n))
+	return n, err
+}
+
+func (c *wireConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.recv, int64(n))
+	return n, err
+}
+
+func (c *wireConn) Wrapped() net.Conn {
+	return c.Conn
+}
+
+func (c *wireConn) get() (sent int, recv int) {
+	return int(atomic.LoadInt64(&c.sent)), int(atomic.LoadInt64(&c.recv))
+}
+
+// findWire walks the chain of wrapped connections starting at c looking for a
+// wire counter.
+func findWire(c net.Conn) *wireConn {
+	for ; c != nil; c = unwrapOnce(c) {
+		if wc, ok := c.(*wireConn); ok {
+			return wc
+		}
+	}
+	return nil
+}
+
+// unwrapOnce returns the conn wrapped by c, or nil if c doesn't expose one.
+func unwrapOnce(c net.Conn) net.Conn {
+	switch t := c.(type) {
+	case interface{ Wrapped() net.Conn }:
+		return t.Wrapped()
+	case interface{ NetConn() net.Conn }:
+		return t.NetConn()
+	case interface{ Unwrap() net.Conn }:
+		return t.Unwrap()
+	default:
+		return nil
+	}
+}
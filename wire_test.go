@@ -0,0 +1,69 @@
+package measured
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/getlantern/mockconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWireBytes(t *testing.T) {
+	sd := mockconn.SucceedingDialer([]byte("1234567890"))
+	raw, err := sd.Dial("", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	conn := Wrap(&framingConn{WrapWire(raw)}, 50*time.Millisecond, nil)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("12345678"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	b := make([]byte, 1000)
+	_, err = conn.Read(b)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	stats := conn.Stats()
+	assert.Equal(t, 8, stats.SentTotal)
+	assert.Equal(t, 10, stats.SentWire, "wire bytes should include framing overhead")
+	assert.Equal(t, 10, stats.RecvTotal)
+	assert.Equal(t, 10, stats.RecvWire)
+}
+
+func TestNoWireBytes(t *testing.T) {
+	sd := mockconn.SucceedingDialer([]byte("1234567890"))
+	raw, err := sd.Dial("", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	conn := Wrap(raw, 50*time.Millisecond, nil)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("12345678"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	stats := conn.Stats()
+	assert.Equal(t, 8, stats.SentTotal)
+	assert.Equal(t, 0, stats.SentWire)
+}
+
+// framingConn simulates a protocol layer that adds a 2 byte header to every
+// write.
+type framingConn struct {
+	net.Conn
+}
+
+func (c *framingConn) Write(b []byte) (int, error) {
+	_, err := c.Conn.Write(append([]byte{0, byte(len(b))}, b...))
+	return len(b), err
+}
+
+func (c *framingConn) NetConn() net.Conn {
+	return c.Conn
+}